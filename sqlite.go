@@ -0,0 +1,6 @@
+//go:build sqlite
+
+package main
+
+// 以 -tags sqlite 編譯時註冊 sqlite3 驅動程式，供 -storage sqlite 使用
+import _ "github.com/mattn/go-sqlite3"
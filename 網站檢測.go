@@ -1,204 +1,5588 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"embed"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
+	"io"
+	"io/fs"
 	"log"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/netip"
+	"net/smtp"
+	neturl "net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
 	"time"
+	"unicode/utf8"
 )
 
 const (
-	logFileName     = "website_monitor.log" // 日誌檔案名稱
-	historyFileName = "status_history.json" // 歷史狀態檔案名稱
-	interval        = 10 * time.Second      // 請求間隔時間
+	defaultAddr        = ":8080"               // 預設的伺服器監聽位址
+	defaultLogFile     = "website_monitor.log" // 預設的日誌檔案路徑
+	defaultHistoryFile = "status_history.json" // 預設的歷史狀態檔案路徑
+	defaultInterval    = 10 * time.Second      // 預設的請求間隔時間
+	defaultTimeout     = 10 * time.Second      // 預設的單次請求逾時時間
+	shutdownTimeout    = 5 * time.Second       // 關閉伺服器時等待進行中請求的時間
+	saveInterval       = 5 * time.Second       // 預設的歷史資料寫入間隔
+
+	defaultRetryBackoff      = 1 * time.Second     // 第一次重試前的預設等待時間，之後每次加倍
+	defaultCertExpiryWarning = 14 * 24 * time.Hour // 憑證到期前開始警告的預設時間
+	defaultWebhookTimeout    = 5 * time.Second     // Webhook 通知的預設逾時時間
+	defaultLatencyEMAAlpha   = 0.3                 // 回應時間指數移動平均的預設平滑係數
+	defaultJitter            = 0.1                 // 錯開檢查時間的預設隨機比例 (檢查間隔的 10%)
+	defaultTimelineBucket    = time.Hour           // 狀態時間軸每一格預設涵蓋的時間
+	defaultRemoteRefresh     = 5 * time.Minute     // 預設重新取得遠端網址清單的間隔
+	defaultPingCount         = 3                   // ping 檢查預設每次送出的 echo 請求數
+	maxPingCount             = 20                  // ping_count 的上限，所有請求共用一次檢查的逾時
+	defaultPingPort          = "80"                // 不允許 ICMP 時改用 TCP ping 的預設端口
+	maxProbes                = 10                  // 每個網址最多的探測點數量
+	maxRemoteListBytes       = 4 << 20             // 遠端網址清單最多讀取的位元組數
+	maxTimelineBuckets       = 100                 // 狀態時間軸最多顯示的格數，只保留最近的部分
+
+	defaultBodyLimit           = 1 << 20  // 每次檢查預設最多保留在記憶體中的回應位元組數 (1 MiB)
+	maxDrainBytes              = 64 << 10 // 關閉回應前最多讀完的剩餘位元組數，少量剩餘時連線可以重複使用
+	eventBufferSize            = 16       // 每個 /events 訂閱者可暫存的事件數
+	defaultLogLines            = 100      // /logs 預設返回的行數
+	maxLogLines                = 1000     // /logs 最多返回的行數
+	maxLogTailBytes            = 1 << 20  // /logs 最多從日誌結尾讀取的位元組數
+	logTailChunk               = 8192     // 往前讀取日誌時每次讀取的位元組數
+	defaultMaxConcurrentChecks = 10       // 預設同時進行的檢查數量上限
+	defaultFlapWindow          = 20       // 偵測頻繁切換時預設檢查的最近紀錄筆數
+	defaultFlapThreshold       = 5        // 預設的頻繁切換次數門檻
+	healthStaleFactor          = 3        // 超過幾倍的檢查間隔沒有完成檢查時 /healthz 視為異常
+	maxRedirects               = 10       // 跟隨重新導向的最大次數，與 net/http 預設相同
+
+	tcpConnectedStatus = http.StatusOK // TCP 連線成功或 ping 收到回覆時記錄的狀態碼
+
+	defaultMaxHistory = 1000 // 每個網址預設最多保留的歷史紀錄筆數
+)
+
+// defaultURLs 設定檔不存在時使用的內建網址清單
+var defaultURLs = []string{
+	"https://zerojudge.tw/",
+	"http://srlb.somee.com/",
+	"http://example.com/404",
+	"http://10.255.255.1",
+	"http://httpstat.us/403",
+	"http://httpstat.us/502",
+}
+
+// urls 目前監控中的網址清單，於啟動時從設定檔載入
+var urls []URLConfig
+
+// checkSlots 限制同時進行的檢查數量，每次請求前放入一個值、結束後取出
+var checkSlots = make(chan struct{}, defaultMaxConcurrentChecks)
+
+// 版本資訊，發佈時以 -ldflags "-X main.version=v1.2.3 -X main.commit=abc1234 -X main.buildTime=2024-01-02T15:04:05Z" 設定，
+// 未設定時為 dev
+var (
+	version   = "dev"
+	commit    = "dev"
+	buildTime = "dev"
+)
+
+// BuildInfo 目前執行中的版本資訊，顯示在頁面底部並由 /version 返回
+type BuildInfo struct {
+	Version   string
+	Commit    string
+	BuildTime string
+	GoVersion string
+}
+
+// buildInfo 返回目前執行中的版本資訊
+func buildInfo() BuildInfo {
+	return BuildInfo{Version: version, Commit: commit, BuildTime: buildTime, GoVersion: runtime.Version()}
+}
+
+// maxRetries 與 retryBackoff 為失敗時的重試次數與第一次重試前的等待時間
+var (
+	maxRetries   int
+	retryBackoff = defaultRetryBackoff
+)
+
+// jitterFraction 錯開檢查時間的隨機比例，jitterEachCheck 為是否每次檢查都加上隨機延遲，
+// 否則只錯開第一次檢查
+var (
+	jitterFraction  = defaultJitter
+	jitterEachCheck bool
+)
+
+// debugLogging 是否輸出除錯層級的日誌
+var debugLogging bool
+
+// jsonLogging 是否以 JSON 格式輸出結構化日誌
+var jsonLogging bool
+
+// logFilePath 日誌檔案路徑，/logs 也從這個檔案讀取
+var logFilePath = defaultLogFile
+
+// debugf 只在開啟除錯日誌時輸出
+func debugf(format string, args ...interface{}) {
+	if !debugLogging {
+		return
+	}
+	if jsonLogging {
+		slog.Debug(fmt.Sprintf(format, args...))
+		return
+	}
+	log.Printf("DEBUG: "+format, args...)
+}
+
+// certExpiryWarning 憑證距離到期少於此時間時顯示警告
+var certExpiryWarning = defaultCertExpiryWarning
+
+// maxHistory 每個網址最多保留的歷史紀錄筆數
+var maxHistory = defaultMaxHistory
+
+// uptimeWindow 計算正常運作百分比時採用的最近檢查次數，0 代表全部歷史紀錄
+var uptimeWindow int
+
+// flapWindow 與 flapThreshold 偵測頻繁切換時檢查的最近紀錄筆數與切換次數門檻
+var (
+	flapWindow    = defaultFlapWindow
+	flapThreshold = defaultFlapThreshold
 )
 
-var urls = []string{
-	"https://zerojudge.tw/",
-	"http://srlb.somee.com/",
-	"http://example.com/404",
-	"http://10.255.255.1",
-	"http://httpstat.us/403",
-	"http://httpstat.us/502",
+// statsWindow 計算平均、最短與最長回應時間時採用的最近檢查次數，0 代表全部歷史紀錄
+var statsWindow int
+
+// defaultHistogramBuckets 預設的回應時間分佈區間上限
+var defaultHistogramBuckets = []time.Duration{100 * time.Millisecond, 300 * time.Millisecond, time.Second, 3 * time.Second}
+
+// histogramBuckets 回應時間分佈的區間上限，依小到大排列，最後另有一個沒有上限的區間
+var histogramBuckets = defaultHistogramBuckets
+
+// timelineBucket 狀態時間軸每一格涵蓋的時間
+var timelineBucket = defaultTimelineBucket
+
+// Duration 可從 JSON 字串（例如 "5s"、"5m"）解析的時間長度
+type Duration time.Duration
+
+// UnmarshalText 以 time.ParseDuration 解析字串格式的時間長度；
+// 實作 UnmarshalText 而不是 UnmarshalJSON，寫成數字時 JSON 解析器的錯誤才會帶有欄位名稱
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// String 以 time.Duration 的格式輸出，例如 "1m30s"
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalJSON 將時間長度輸出為字串格式
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Config 設定檔結構
+type Config struct {
+	Interval          Duration          `json:"interval,omitempty"`              // 全域預設的檢查間隔
+	UptimeWindow      int               `json:"uptime_window,omitempty"`         // 計算正常運作百分比的最近檢查次數，0 代表全部
+	StatsWindow       int               `json:"stats_window,omitempty"`          // 計算回應時間統計的最近檢查次數，0 代表全部
+	FlapWindow        int               `json:"flap_window,omitempty"`           // 偵測頻繁切換時檢查的最近紀錄筆數
+	FlapThreshold     int               `json:"flap_threshold,omitempty"`        // 切換次數達到此值時視為頻繁切換，設為負數停用
+	MaxHistory        int               `json:"max_history,omitempty"`           // 每個網址最多保留的歷史紀錄筆數
+	SaveInterval      Duration          `json:"save_interval,omitempty"`         // 歷史資料寫入檔案的間隔
+	Retries           int               `json:"retries,omitempty"`               // 連線錯誤或 5xx 時的重試次數
+	MaxConcurrent     int               `json:"max_concurrent_checks,omitempty"` // 同時進行的檢查數量上限
+	BodyLimit         int64             `json:"body_limit,omitempty"`            // 每次檢查最多保留在記憶體中的回應位元組數，網址可各自設定
+	UserAgent         string            `json:"user_agent,omitempty"`            // HTTP 檢查的 User-Agent，預設為 website-detection/<版本>，網址可各自設定
+	RetryBackoff      Duration          `json:"retry_backoff,omitempty"`         // 第一次重試前的等待時間，之後每次加倍
+	CertExpiryWarning Duration          `json:"cert_expiry_warning,omitempty"`   // 憑證距離到期少於此時間時顯示警告
+	AlertCooldown     Duration          `json:"alert_cooldown,omitempty"`        // 同一網址同一種通知的最短間隔，網址可各自設定，預設不限制
+	LatencyEMAAlpha   float64           `json:"latency_ema_alpha,omitempty"`     // 回應時間指數移動平均的平滑係數 (0-1]，越大越接近最新的值
+	Jitter            float64           `json:"jitter,omitempty"`                // 錯開檢查時間的隨機比例 (0-1]，設為負數停用
+	JitterEachCheck   bool              `json:"jitter_each_check,omitempty"`     // 每次檢查都加上隨機延遲，而不是只錯開第一次檢查
+	HistogramBuckets  []Duration        `json:"histogram_buckets,omitempty"`     // 回應時間分佈的區間上限，依小到大排列
+	TimelineBucket    Duration          `json:"timeline_bucket,omitempty"`       // 狀態時間軸每一格涵蓋的時間，預設 1 小時
+	StatusClasses     []StatusRule      `json:"status_classes,omitempty"`        // 狀態碼分類規則，優先於內建規則
+	RedirectsUp       bool              `json:"redirects_up,omitempty"`          // 將 3xx 重新導向視為正常，預設只有 2xx 正常
+	Proxy             string            `json:"proxy,omitempty"`                 // 所有 HTTP 檢查使用的代理伺服器，優先於 HTTP_PROXY 環境變數
+	Email             *EmailConfig      `json:"email,omitempty"`                 // 狀態轉換時的郵件通知，未設定時不寄信
+	Auth              *AuthConfig       `json:"auth,omitempty"`                  // 保護網頁與 API 的 HTTP Basic 驗證，未設定時不需要驗證
+	Webhooks          []WebhookConfig   `json:"webhooks,omitempty"`              // 狀態轉換時通知的 Webhook
+	Escalation        *EscalationConfig `json:"escalation,omitempty"`            // 持續異常超過一段時間時的升級通知
+	URLs              []URLConfig       `json:"urls"`                            // 要監控的網址清單
+	RemoteURLs        *RemoteURLsConfig `json:"remote_urls,omitempty"`           // 另外從 HTTP(S) 端點取得並定期更新的網址清單
+	Groups            []GroupConfig     `json:"groups,omitempty"`                // 共用設定的網址群組
+
+	remoteCount int // URLs 結尾來自遠端清單的網址數，定期更新遠端清單時只替換這部分
+}
+
+// RemoteURLsConfig 從 HTTP(S) 端點取得要監控的網址清單，端點返回與 urls 相同格式的 JSON 陣列，
+// 或包含 urls 欄位的物件
+type RemoteURLsConfig struct {
+	URL     string            `json:"url"`
+	Refresh Duration          `json:"refresh,omitempty"` // 重新取得的間隔，預設 5 分鐘
+	Headers map[string]string `json:"headers,omitempty"` // 請求標頭，例如 Authorization，可使用 ${VAR}
+}
+
+// GroupConfig 一組共用設定的網址，群組內的網址未設定的欄位沿用 Defaults
+type GroupConfig struct {
+	Name     string      `json:"name"`
+	Defaults URLConfig   `json:"defaults"`
+	URLs     []URLConfig `json:"urls"`
+}
+
+// URLConfig 單一監控網址的設定
+// 檢查間隔的優先順序：網址自身的 interval > 設定檔的全域 interval > 內建預設值
+type URLConfig struct {
+	URL      string   `json:"url"`
+	Name     string   `json:"name,omitempty"`     // 頁面與 API 顯示的名稱，未設定時顯示網址；網址仍是歷史資料的識別
+	Interval Duration `json:"interval,omitempty"` // 此網址的檢查間隔，未設定時使用全域預設值
+	Method   string   `json:"method,omitempty"`   // 檢查使用的 HTTP 方法 (GET、HEAD、POST、PUT 或 PATCH)，預設為 GET
+
+	// 請求內容，只能用於 POST、PUT 與 PATCH；不會寫入歷史檔案，日誌中也只記錄長度
+	Body        string `json:"body,omitempty"`
+	ContentType string `json:"content_type,omitempty"` // 請求內容的 Content-Type，預設為 application/json
+
+	// 內容檢查：設定後回應內容必須包含 Content 字串且符合 ContentRegex 才算正常
+	Content      string `json:"content,omitempty"`
+	ContentRegex string `json:"content_regex,omitempty"`
+
+	// 回應內容 (解壓縮後) 的位元組數範圍，超出範圍時視為異常，用來發現被截斷或空白的回應；0 代表不限制
+	MinBodySize int64 `json:"min_body_size,omitempty"`
+	MaxBodySize int64 `json:"max_body_size,omitempty"`
+
+	// BodyLimit 最多保留在記憶體中進行內容檢查的回應位元組數，超過的部分不保留並標示為截斷；未設定時使用全域的 body_limit
+	BodyLimit int64 `json:"body_limit,omitempty"`
+
+	// UserAgent 請求的 User-Agent，未設定時使用全域的 user_agent；headers 中的 User-Agent 優先
+	UserAgent string `json:"user_agent,omitempty"`
+
+	// DegradedThreshold 回應時間的指數移動平均超過此值時視為 degraded，未設定時不啟用；
+	// 以移動平均判斷，單次的延遲尖峰不會讓狀態變成 degraded
+	DegradedThreshold Duration `json:"degraded_threshold,omitempty"`
+
+	// AlertCooldown 同一種通知 (異常、恢復、IP 變更) 的最短間隔，冷卻期間的狀態轉換延後到冷卻結束才通知；
+	// 未設定時使用全域的 alert_cooldown
+	AlertCooldown Duration `json:"alert_cooldown,omitempty"`
+
+	// IPVersion 限定連線使用的位址類型："4" 只用 IPv4、"6" 只用 IPv6，未設定時不限制
+	IPVersion string `json:"ip_version,omitempty"`
+
+	// PingCount ping:// 網址每次檢查送出的 echo 請求數，預設 3
+	PingCount int `json:"ping_count,omitempty"`
+
+	// Headers 附加在請求上的自訂標頭，Host 標頭會設定為請求的 Host
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// 驗證資訊，只用於送出請求，不會寫入歷史檔案或日誌
+	BasicAuth   *BasicAuth `json:"basic_auth,omitempty"`
+	BearerToken string     `json:"bearer_token,omitempty"`
+
+	// ExpectedStatus 視為正常的狀態碼，例如需要驗證的健康檢查回應 401 或 403，未設定時為 2xx
+	ExpectedStatus []int `json:"expected_status,omitempty"`
+
+	// Proxy 此網址使用的代理伺服器，"direct" 代表不使用代理，未設定時沿用全域設定
+	Proxy string `json:"proxy,omitempty"`
+
+	// FollowRedirects 是否跟隨重新導向，未設定時預設跟隨；設為 false 時記錄 3xx 本身
+	FollowRedirects *bool `json:"follow_redirects,omitempty"`
+
+	// Maintenance 維護時段，期間照常檢查與記錄，但顯示為 maintenance 且不發送通知
+	Maintenance []MaintenanceWindow `json:"maintenance,omitempty"`
+
+	// CaptureHeaders 每次檢查記錄的回應標頭名稱，最多 maxCapturedHeaders 個
+	CaptureHeaders []string `json:"capture_headers,omitempty"`
+
+	// ExpectHeaders 回應標頭必須包含的值 (不分大小寫比對標頭名稱)，不符合時視為異常；這些標頭也會一併記錄
+	ExpectHeaders map[string]string `json:"expect_headers,omitempty"`
+
+	// HealthRules 依序比對的正常條件，任何一條符合即視為正常，並記錄符合的規則；
+	// 設定後取代 expected_status、content、content_regex 與 expect_headers 的判斷
+	HealthRules []HealthRule `json:"health_rules,omitempty"`
+
+	// Critical 重要的網址，異常時 /healthz 返回 503；預設為 false，非重要網址的異常只會列出
+	Critical bool `json:"critical,omitempty"`
+
+	// InsecureSkipVerify 不驗證此網址的 TLS 憑證，用於使用自簽憑證的內部服務；仍會記錄憑證到期時間
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+
+	// AlertOnIPChange 連線到從未見過的 IP 時發送通知；在最近見過的 IP 之間輪替不會通知
+	AlertOnIPChange bool `json:"alert_on_ip_change,omitempty"`
+
+	// DetectContentChange 記錄每次回應內容 (最多 body_limit 位元組) 的 SHA-256，與前一次正常檢查不同時標示內容已變更；
+	// 內容每次都不同的動態頁面不適合開啟
+	DetectContentChange bool `json:"detect_content_change,omitempty"`
+
+	// Tags 用於分類的標籤，例如團隊、環境或服務，可用 ?tag= 篩選；群組的 defaults 中的標籤會加入群組內的每個網址
+	Tags []string `json:"tags,omitempty"`
+
+	// Probes 以多個代理伺服器或來源 IP 檢查同一個網址，全部失敗才算異常，部分失敗時標示為 degraded
+	Probes []ProbeConfig `json:"probes,omitempty"`
+
+	Group string `json:"-"` // 所屬群組的名稱，由 groups 設定展開時填入
+
+	contentPattern *regexp.Regexp // 載入設定時由 ContentRegex 編譯而成
+	proxyURL       *neturl.URL    // 載入設定時由 Proxy 解析而成，"direct" 時為 nil
+	sourceIP       net.IP         // 探測點的來源 IP，由 forProbe 填入
+}
+
+// ProbeConfig 一個探測點：以不同的代理伺服器或本機來源 IP 檢查同一個網址，用於分辨區域性的故障
+type ProbeConfig struct {
+	Name     string `json:"name"`
+	Proxy    string `json:"proxy,omitempty"`     // 此探測點使用的代理伺服器，"direct" 代表不使用；未設定時沿用網址的設定
+	SourceIP string `json:"source_ip,omitempty"` // 連線時綁定的本機來源 IP，未設定時由系統選擇
+
+	proxyURL *neturl.URL // 載入設定時由 Proxy 解析而成，"direct" 時為 nil
+	sourceIP net.IP      // 載入設定時由 SourceIP 解析而成
+}
+
+// forProbe 返回以探測點的代理伺服器與來源 IP 檢查的網址設定
+func (c URLConfig) forProbe(probe ProbeConfig) URLConfig {
+	c.Probes = nil
+	if probe.Proxy != "" {
+		c.Proxy, c.proxyURL = probe.Proxy, probe.proxyURL
+	}
+	c.sourceIP = probe.sourceIP
+	return c
+}
+
+// HealthRule 一組判斷正常的條件：狀態碼符合，且設定的內容與標頭也都符合時才算符合
+type HealthRule struct {
+	Name         string            `json:"name,omitempty"` // 顯示用的名稱，未設定時為 "rule N"
+	Status       []int             `json:"status"`
+	Content      string            `json:"content,omitempty"`
+	ContentRegex string            `json:"content_regex,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"` // 回應標頭必須包含的值，空字串代表標頭必須存在
+
+	contentPattern *regexp.Regexp // 載入設定時由 ContentRegex 編譯而成
+}
+
+// matches 判斷回應是否符合這條規則
+func (r HealthRule) matches(resp *http.Response, body []byte) bool {
+	if !slices.Contains(r.Status, resp.StatusCode) {
+		return false
+	}
+	if r.Content != "" && !bytes.Contains(body, []byte(r.Content)) {
+		return false
+	}
+	if r.contentPattern != nil && !r.contentPattern.Match(body) {
+		return false
+	}
+	return headersMatch(resp.Header, r.Headers)
+}
+
+// matchHealthRule 依序比對網址的正常條件，返回第一條符合的規則名稱
+func (c URLConfig) matchHealthRule(resp *http.Response, body []byte) (string, bool) {
+	for _, rule := range c.HealthRules {
+		if rule.matches(resp, body) {
+			return rule.Name, true
+		}
+	}
+	return "", false
+}
+
+// BasicAuth HTTP Basic 驗證的帳號密碼
+type BasicAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// MaintenanceWindow 一段維護時段，可設定一次性的 start/end，
+// 或每週重複的 days 加上每天的 from/to ("HH:MM"，伺服器時區)，from 晚於 to 代表跨過午夜
+type MaintenanceWindow struct {
+	Start time.Time `json:"start,omitempty"` // 一次性維護的開始時間 (RFC3339)
+	End   time.Time `json:"end,omitempty"`   // 一次性維護的結束時間 (RFC3339)
+	Days  []string  `json:"days,omitempty"`  // 重複維護的星期，例如 ["sat", "sun"]，未設定代表每天
+	From  string    `json:"from,omitempty"`  // 重複維護每天的開始時間
+	To    string    `json:"to,omitempty"`    // 重複維護每天的結束時間
+
+	weekdays [7]bool // 由 Days 解析而成，全部為 false 代表每天
+	from, to int     // 由 From、To 解析而成的分鐘數 (0-1439)
+}
+
+// weekdayNames Days 可使用的星期名稱
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parse 驗證維護時段並解析重複時段的星期與時間
+func (w *MaintenanceWindow) parse() error {
+	if !w.Start.IsZero() || !w.End.IsZero() {
+		if w.From != "" || w.To != "" || len(w.Days) > 0 {
+			return errors.New("start/end and days/from/to are mutually exclusive")
+		}
+		if !w.End.After(w.Start) {
+			return errors.New("end must be after start")
+		}
+		return nil
+	}
+
+	from, err := time.Parse("15:04", w.From)
+	if err != nil {
+		return fmt.Errorf("invalid from %q, expected HH:MM", w.From)
+	}
+	to, err := time.Parse("15:04", w.To)
+	if err != nil {
+		return fmt.Errorf("invalid to %q, expected HH:MM", w.To)
+	}
+	w.from = from.Hour()*60 + from.Minute()
+	w.to = to.Hour()*60 + to.Minute()
+	if w.from == w.to {
+		return errors.New("from and to must differ")
+	}
+	for _, day := range w.Days {
+		weekday, ok := weekdayNames[strings.ToLower(day)]
+		if !ok {
+			return fmt.Errorf("unknown day %q", day)
+		}
+		w.weekdays[weekday] = true
+	}
+	return nil
+}
+
+// active 判斷時間 t 是否在維護時段內
+func (w MaintenanceWindow) active(t time.Time) bool {
+	if !w.Start.IsZero() {
+		return !t.Before(w.Start) && t.Before(w.End)
+	}
+
+	t = t.Local()
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	switch {
+	case w.from < w.to:
+		if minute < w.from || minute >= w.to {
+			return false
+		}
+	case minute >= w.from:
+		// 跨過午夜的時段，午夜前屬於當天開始的時段
+	case minute < w.to:
+		// 跨過午夜的時段，午夜後屬於前一天開始的時段
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+
+	everyDay := w.weekdays == [7]bool{}
+	return everyDay || w.weekdays[day]
+}
+
+// inMaintenance 判斷網址在時間 t 是否處於任何一個維護時段
+func (c URLConfig) inMaintenance(t time.Time) bool {
+	for _, window := range c.Maintenance {
+		if window.active(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// withDefaults 以 defaults 補上網址未設定的欄位，標頭會合併且以網址自身的值優先
+func (c URLConfig) withDefaults(defaults URLConfig) URLConfig {
+	if c.Interval == 0 {
+		c.Interval = defaults.Interval
+	}
+	if c.Method == "" {
+		c.Method = defaults.Method
+	}
+	if c.Body == "" {
+		c.Body = defaults.Body
+	}
+	if c.ContentType == "" {
+		c.ContentType = defaults.ContentType
+	}
+	if c.Content == "" {
+		c.Content = defaults.Content
+	}
+	if c.ContentRegex == "" {
+		c.ContentRegex = defaults.ContentRegex
+	}
+	if c.MinBodySize == 0 {
+		c.MinBodySize = defaults.MinBodySize
+	}
+	if c.MaxBodySize == 0 {
+		c.MaxBodySize = defaults.MaxBodySize
+	}
+	if c.BodyLimit == 0 {
+		c.BodyLimit = defaults.BodyLimit
+	}
+	if c.UserAgent == "" {
+		c.UserAgent = defaults.UserAgent
+	}
+	if c.AlertCooldown == 0 {
+		c.AlertCooldown = defaults.AlertCooldown
+	}
+	if len(c.Probes) == 0 {
+		c.Probes = defaults.Probes
+	}
+	if c.DegradedThreshold == 0 {
+		c.DegradedThreshold = defaults.DegradedThreshold
+	}
+	if c.IPVersion == "" {
+		c.IPVersion = defaults.IPVersion
+	}
+	if c.PingCount == 0 {
+		c.PingCount = defaults.PingCount
+	}
+	if len(defaults.Headers) > 0 {
+		headers := make(map[string]string, len(defaults.Headers)+len(c.Headers))
+		for name, value := range defaults.Headers {
+			headers[name] = value
+		}
+		for name, value := range c.Headers {
+			headers[name] = value
+		}
+		c.Headers = headers
+	}
+	if c.BasicAuth == nil && c.BearerToken == "" {
+		c.BasicAuth = defaults.BasicAuth
+		c.BearerToken = defaults.BearerToken
+	}
+	if c.ExpectedStatus == nil {
+		c.ExpectedStatus = defaults.ExpectedStatus
+	}
+	if c.Proxy == "" {
+		c.Proxy = defaults.Proxy
+	}
+	if c.FollowRedirects == nil {
+		c.FollowRedirects = defaults.FollowRedirects
+	}
+	if c.Maintenance == nil {
+		c.Maintenance = defaults.Maintenance
+	}
+	if c.CaptureHeaders == nil {
+		c.CaptureHeaders = defaults.CaptureHeaders
+	}
+	if c.HealthRules == nil {
+		c.HealthRules = defaults.HealthRules
+	}
+	if len(defaults.ExpectHeaders) > 0 {
+		expect := make(map[string]string, len(defaults.ExpectHeaders)+len(c.ExpectHeaders))
+		for name, value := range defaults.ExpectHeaders {
+			expect[name] = value
+		}
+		for name, value := range c.ExpectHeaders {
+			expect[name] = value
+		}
+		c.ExpectHeaders = expect
+	}
+	c.AlertOnIPChange = c.AlertOnIPChange || defaults.AlertOnIPChange
+	c.InsecureSkipVerify = c.InsecureSkipVerify || defaults.InsecureSkipVerify
+	c.Critical = c.Critical || defaults.Critical
+	c.DetectContentChange = c.DetectContentChange || defaults.DetectContentChange
+	if len(defaults.Tags) > 0 {
+		c.Tags = append(slices.Clone(defaults.Tags), c.Tags...)
+	}
+	return c
+}
+
+// defaultUserAgent 返回預設的請求 User-Agent，包含版本讓對方的管理者能辨識來源
+func defaultUserAgent() string {
+	return "website-detection/" + version + " (+https://github.com/ben1980s/Website-detection)"
+}
+
+// validHeaderValue 檢查標頭值不包含換行等控制字元 (tab 除外)
+func validHeaderValue(value string) bool {
+	return !strings.ContainsFunc(value, func(r rune) bool {
+		return r != '\t' && (r < ' ' || r == 0x7f)
+	})
+}
+
+// validProbes 檢查探測點的名稱、代理伺服器與來源 IP，並解析供檢查時使用
+func validProbes(probes []ProbeConfig) ([]ProbeConfig, error) {
+	if len(probes) > maxProbes {
+		return nil, fmt.Errorf("probes: at most %d probes are allowed, got %d", maxProbes, len(probes))
+	}
+	probes = slices.Clone(probes)
+	seen := make(map[string]bool, len(probes))
+	for i := range probes {
+		probe := &probes[i]
+		probe.Name = strings.TrimSpace(probe.Name)
+		if probe.Name == "" {
+			return nil, fmt.Errorf("probes[%d]: missing name", i)
+		}
+		if seen[probe.Name] {
+			return nil, fmt.Errorf("probes[%d]: duplicate name %q", i, probe.Name)
+		}
+		seen[probe.Name] = true
+		if probe.Proxy != "" && probe.Proxy != "direct" {
+			proxyURL, err := parseProxy(probe.Proxy)
+			if err != nil {
+				return nil, fmt.Errorf("probes[%d] (%s): proxy = %q: %v", i, probe.Name, probe.Proxy, err)
+			}
+			probe.proxyURL = proxyURL
+		}
+		if probe.SourceIP != "" {
+			probe.sourceIP = net.ParseIP(probe.SourceIP)
+			if probe.sourceIP == nil {
+				return nil, fmt.Errorf("probes[%d] (%s): source_ip = %q: not an IP address", i, probe.Name, probe.SourceIP)
+			}
+		}
+	}
+	return probes, nil
+}
+
+// cleanTags 去除標籤前後的空白、空白的標籤與重複的標籤，保留第一次出現的順序
+func cleanTags(tags []string) []string {
+	var cleaned []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && !slices.Contains(cleaned, tag) {
+			cleaned = append(cleaned, tag)
+		}
+	}
+	return cleaned
+}
+
+// flattenGroups 將群組展開為一般的網址清單，並記錄每個網址所屬的群組
+func flattenGroups(groups []GroupConfig) []URLConfig {
+	var targets []URLConfig
+	for _, group := range groups {
+		for _, target := range group.URLs {
+			target = target.withDefaults(group.Defaults)
+			target.Group = group.Name
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// followRedirects 返回是否跟隨重新導向
+func (c URLConfig) followRedirects() bool {
+	return c.FollowRedirects == nil || *c.FollowRedirects
+}
+
+// expectsStatus 判斷狀態碼是否為此網址預期的正常回應
+func (c URLConfig) expectsStatus(code int) bool {
+	if len(c.ExpectedStatus) == 0 {
+		return isUp(code)
+	}
+	for _, expected := range c.ExpectedStatus {
+		if code == expected {
+			return true
+		}
+	}
+	return false
+}
+
+// hasContentCheck 是否設定了內容檢查
+func (c URLConfig) hasContentCheck() bool {
+	return c.Content != "" || c.contentPattern != nil
+}
+
+// hasSizeCheck 是否設定了回應內容大小範圍
+func (c URLConfig) hasSizeCheck() bool {
+	return c.MinBodySize > 0 || c.MaxBodySize > 0
+}
+
+// sizeCountLimit 檢查大小範圍時最多需要讀取的位元組數：設定 max_body_size 時多讀一個位元組，
+// 才能分辨內容剛好等於上限還是超過上限；只設定 min_body_size 時讀到下限即可
+func (c URLConfig) sizeCountLimit() int64 {
+	if c.MaxBodySize > 0 {
+		return c.MaxBodySize + 1
+	}
+	return c.MinBodySize
+}
+
+// sizeMatches 檢查回應內容大小是否在設定的範圍內
+func (c URLConfig) sizeMatches(size int64) bool {
+	if c.MinBodySize > 0 && size < c.MinBodySize {
+		return false
+	}
+	return c.MaxBodySize <= 0 || size <= c.MaxBodySize
+}
+
+// UnmarshalJSON 讓網址清單同時接受純字串與物件兩種寫法
+func (c *URLConfig) UnmarshalJSON(data []byte) error {
+	var rawURL string
+	if err := json.Unmarshal(data, &rawURL); err == nil {
+		*c = URLConfig{URL: rawURL}
+		return nil
+	}
+
+	type plain URLConfig // 避免遞迴呼叫 UnmarshalJSON
+	if err := json.Unmarshal(data, (*plain)(c)); err != nil {
+		// 網址物件另外解析，錯誤不會帶有在清單中的位置，改以網址標示是哪一個項目
+		var probe struct {
+			URL string `json:"url"`
+		}
+		json.Unmarshal(data, &probe)
+		return fmt.Errorf("URL %q: %v", probe.URL, describeDecodeError(err))
+	}
+	return nil
+}
+
+// defaultConfig 由內建網址清單組成的預設設定
+func defaultConfig() Config {
+	var config Config
+	for _, rawURL := range defaultURLs {
+		config.URLs = append(config.URLs, URLConfig{URL: rawURL})
+	}
+	return config
+}
+
+// 從設定檔讀取監控網址，檔案不存在時使用內建預設清單；
+// 檔案無法解析時返回錯誤，不再改用預設清單，避免打錯字時默默監控錯誤的網址
+func loadConfig(path string) (Config, []string, error) {
+	config, err := readConfigFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return config, nil, err
+		}
+		if os.Getenv(envURLs) != "" {
+			log.Printf("Config file %s not found, using %s", path, envURLs)
+		} else {
+			log.Printf("Config file %s not found, using built-in URL list", path)
+		}
+		config = defaultConfig()
+	}
+	// 環境變數優先於設定檔，讓容器中不掛載任何檔案也能設定
+	if err := applyEnvConfig(&config); err != nil {
+		log.Printf("Error in environment configuration: %v", err)
+	}
+	config, problems := normalizeConfig(config)
+	mergeRemoteURLs(&config)
+	return config, problems, nil
+}
+
+// normalizeConfig 補上預設值並檢查每個設定，無效的項目記錄日誌後略過或改用預設值，
+// 返回每個問題的說明 (包含欄位名稱與設定的值)，重新載入設定與 -check 模式據此拒絕有問題的設定
+func normalizeConfig(config Config) (Config, []string) {
+	var problems []string
+	problem := func(format string, args ...any) {
+		message := fmt.Sprintf(format, args...)
+		log.Print(message)
+		problems = append(problems, message)
+	}
+
+	if config.Interval < 0 {
+		problem("Invalid config: interval = %v: must not be negative, using the default %v", config.Interval, defaultInterval)
+	}
+	if config.Interval <= 0 {
+		config.Interval = Duration(defaultInterval)
+	}
+	if config.FlapWindow < 0 {
+		problem("Invalid config: flap_window = %d: must not be negative, using the default %d", config.FlapWindow, defaultFlapWindow)
+	}
+	if config.FlapWindow <= 0 {
+		config.FlapWindow = defaultFlapWindow
+	}
+	if config.UptimeWindow < 0 {
+		problem("Invalid config: uptime_window = %d: must not be negative, using all history", config.UptimeWindow)
+		config.UptimeWindow = 0
+	}
+	if config.StatsWindow < 0 {
+		problem("Invalid config: stats_window = %d: must not be negative, using all history", config.StatsWindow)
+		config.StatsWindow = 0
+	}
+	if config.FlapThreshold == 0 {
+		config.FlapThreshold = defaultFlapThreshold
+	}
+	if config.MaxHistory < 0 {
+		problem("Invalid config: max_history = %d: must not be negative, using the default %d", config.MaxHistory, defaultMaxHistory)
+	}
+	if config.MaxHistory <= 0 {
+		config.MaxHistory = defaultMaxHistory
+	}
+	if config.SaveInterval < 0 {
+		problem("Invalid config: save_interval = %v: must not be negative, using the default %v", config.SaveInterval, saveInterval)
+	}
+	if config.SaveInterval <= 0 {
+		config.SaveInterval = Duration(saveInterval)
+	}
+	if config.Retries < 0 {
+		problem("Invalid config: retries = %d: must not be negative, not retrying", config.Retries)
+		config.Retries = 0
+	}
+	if config.RetryBackoff < 0 {
+		problem("Invalid config: retry_backoff = %v: must not be negative, using the default %v", config.RetryBackoff, defaultRetryBackoff)
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = Duration(defaultRetryBackoff)
+	}
+	if config.BodyLimit < 0 {
+		problem("Invalid config: body_limit = %d: must not be negative, using the default %d", config.BodyLimit, defaultBodyLimit)
+	}
+	if config.BodyLimit <= 0 {
+		config.BodyLimit = defaultBodyLimit
+	}
+	if !validHeaderValue(config.UserAgent) {
+		problem("Invalid config: user_agent = %q: must not contain control characters, using the default %q", config.UserAgent, defaultUserAgent())
+		config.UserAgent = ""
+	}
+	if config.UserAgent == "" {
+		config.UserAgent = defaultUserAgent()
+	}
+	if config.MaxConcurrent < 0 {
+		problem("Invalid config: max_concurrent_checks = %d: must not be negative, using the default %d", config.MaxConcurrent, defaultMaxConcurrentChecks)
+	}
+	if config.MaxConcurrent <= 0 {
+		config.MaxConcurrent = defaultMaxConcurrentChecks
+	}
+	if config.CertExpiryWarning < 0 {
+		problem("Invalid config: cert_expiry_warning = %v: must not be negative, using the default %v", config.CertExpiryWarning, defaultCertExpiryWarning)
+	}
+	if config.CertExpiryWarning <= 0 {
+		config.CertExpiryWarning = Duration(defaultCertExpiryWarning)
+	}
+	if config.AlertCooldown < 0 {
+		problem("Invalid config: alert_cooldown = %v: must not be negative, disabling the cooldown", config.AlertCooldown)
+		config.AlertCooldown = 0
+	}
+	if config.TimelineBucket < 0 {
+		problem("Invalid config: timeline_bucket = %v: must not be negative, using the default %v", config.TimelineBucket, defaultTimelineBucket)
+	}
+	if config.TimelineBucket <= 0 {
+		config.TimelineBucket = Duration(defaultTimelineBucket)
+	}
+	if config.LatencyEMAAlpha < 0 || config.LatencyEMAAlpha > 1 {
+		problem("Invalid config: latency_ema_alpha = %v: must be between 0 and 1, using the default %v", config.LatencyEMAAlpha, defaultLatencyEMAAlpha)
+		config.LatencyEMAAlpha = 0
+	}
+	if config.LatencyEMAAlpha == 0 {
+		config.LatencyEMAAlpha = defaultLatencyEMAAlpha
+	}
+	if config.Jitter > 1 {
+		problem("Invalid config: jitter = %v: must not exceed 1, using the default %v", config.Jitter, defaultJitter)
+		config.Jitter = 0
+	}
+	if config.Jitter == 0 {
+		config.Jitter = defaultJitter
+	}
+	var rules []StatusRule
+	for i, rule := range config.StatusClasses {
+		if err := rule.validate(); err != nil {
+			problem("Ignoring invalid config: status_classes[%d]: %v", i, err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	config.StatusClasses = rules
+	for i, bound := range config.HistogramBuckets {
+		if bound <= 0 || (i > 0 && bound <= config.HistogramBuckets[i-1]) {
+			problem("Invalid config: histogram_buckets[%d] = %v: buckets must be positive and increasing, using defaults", i, bound)
+			config.HistogramBuckets = nil
+			break
+		}
+	}
+	config.Webhooks = validWebhooks("webhooks", config.Webhooks, problem)
+	if remote := config.RemoteURLs; remote != nil {
+		if remote.Refresh < 0 {
+			problem("Invalid config: remote_urls.refresh = %v: must not be negative, using the default %v", remote.Refresh, defaultRemoteRefresh)
+		}
+		if remote.Refresh <= 0 {
+			remote.Refresh = Duration(defaultRemoteRefresh)
+		}
+	}
+	if escalation := config.Escalation; escalation != nil {
+		if escalation.After < 0 {
+			problem("Invalid config: escalation.after = %v: must not be negative, escalation disabled", escalation.After)
+			escalation.After = 0
+		}
+		escalation.Webhooks = validWebhooks("escalation.webhooks", escalation.Webhooks, problem)
+	}
+
+	// 跳過格式錯誤的網址，而不是讓整個程式停止
+	var valid []URLConfig
+	for _, target := range config.URLs {
+		if err := validateURL(target.URL); err != nil {
+			problem("Skipping URL %q: url: %v", target.URL, err)
+			continue
+		}
+		if target.Interval < 0 {
+			problem("Skipping URL %q: interval = %v: must not be negative", target.URL, target.Interval)
+			continue
+		}
+		if target.Interval == 0 {
+			target.Interval = config.Interval
+		}
+		if target.DegradedThreshold < 0 {
+			problem("Skipping URL %q: degraded_threshold = %v: must not be negative", target.URL, target.DegradedThreshold)
+			continue
+		}
+		if err := parseMaintenance(target.Maintenance); err != nil {
+			problem("Skipping URL %q: maintenance: %v", target.URL, err)
+			continue
+		}
+		if target.IPVersion != "" && target.IPVersion != "4" && target.IPVersion != "6" {
+			problem("Skipping URL %q: ip_version = %q: must be \"4\" or \"6\"", target.URL, target.IPVersion)
+			continue
+		}
+		target.Tags = cleanTags(target.Tags)
+		if target.AlertCooldown < 0 {
+			problem("Skipping URL %q: alert_cooldown = %v: must not be negative", target.URL, target.AlertCooldown)
+			continue
+		}
+		if target.AlertCooldown == 0 {
+			target.AlertCooldown = config.AlertCooldown
+		}
+		probes, err := validProbes(target.Probes)
+		if err != nil {
+			problem("Skipping URL %q: %v", target.URL, err)
+			continue
+		}
+		target.Probes = probes
+		if len(probes) > 0 && target.AlertOnIPChange {
+			log.Printf("URL %q has probes, ignoring alert_on_ip_change", target.URL)
+			target.AlertOnIPChange = false
+		}
+		if kind := checkKind(target.URL); kind == kindTCP || kind == kindPing {
+			// TCP 與 ping 檢查不送出 HTTP 請求，HTTP 相關的設定都不適用；連線時間同樣計入移動平均，degraded_threshold 仍然適用
+			if target.Method != "" || target.Content != "" || target.ContentRegex != "" || len(target.Headers) > 0 || len(target.ExpectedStatus) > 0 || target.Body != "" || target.Proxy != "" ||
+				len(target.CaptureHeaders) > 0 || len(target.ExpectHeaders) > 0 || target.hasSizeCheck() || target.InsecureSkipVerify || len(target.HealthRules) > 0 || target.BodyLimit != 0 || target.DetectContentChange || target.UserAgent != "" ||
+				target.ContentType != "" || target.BasicAuth != nil || target.BearerToken != "" || target.FollowRedirects != nil {
+				log.Printf("URL %q is a %s check, ignoring HTTP-only settings", target.URL, kind)
+			}
+			for i := range target.Probes {
+				if probe := &target.Probes[i]; probe.Proxy != "" {
+					log.Printf("URL %q is a %s check, ignoring the proxy of probe %q", target.URL, kind, probe.Name)
+					probe.Proxy, probe.proxyURL = "", nil
+				}
+			}
+			pingCount := 0
+			if kind == kindPing {
+				pingCount = target.PingCount
+				if pingCount < 0 || pingCount > maxPingCount {
+					problem("Skipping URL %q: ping_count = %d: must be between 1 and %d", target.URL, pingCount, maxPingCount)
+					continue
+				}
+				if pingCount == 0 {
+					pingCount = defaultPingCount
+				}
+			} else if target.PingCount != 0 {
+				log.Printf("URL %q is not a ping check, ignoring ping_count", target.URL)
+			}
+			valid = append(valid, URLConfig{URL: target.URL, Interval: target.Interval, IPVersion: target.IPVersion, PingCount: pingCount, DegradedThreshold: target.DegradedThreshold, Maintenance: target.Maintenance, AlertOnIPChange: target.AlertOnIPChange, Critical: target.Critical, Name: target.Name, Group: target.Group, Tags: target.Tags, AlertCooldown: target.AlertCooldown, Probes: target.Probes})
+			continue
+		}
+		target.Method = strings.ToUpper(target.Method)
+		if target.Method == "" {
+			target.Method = http.MethodGet
+		}
+		allowsBody, ok := checkMethods[target.Method]
+		if !ok {
+			problem("Skipping URL %q: method = %q: unsupported method", target.URL, target.Method)
+			continue
+		}
+		if target.Body != "" && !allowsBody {
+			problem("Skipping URL %q: body: method %s does not allow a request body", target.URL, target.Method)
+			continue
+		}
+		if target.Body != "" && target.ContentType == "" {
+			target.ContentType = "application/json"
+		}
+		if !validStatusCodes(target.ExpectedStatus) {
+			problem("Skipping URL %q: expected_status = %v: must be HTTP status codes (100-599)", target.URL, target.ExpectedStatus)
+			continue
+		}
+		if target.BasicAuth != nil && target.BearerToken != "" {
+			problem("Skipping URL %q: basic_auth and bearer_token are mutually exclusive", target.URL)
+			continue
+		}
+		if target.Proxy != "" && target.Proxy != "direct" {
+			proxyURL, err := parseProxy(target.Proxy)
+			if err != nil {
+				problem("Skipping URL %q: proxy = %q: %v", target.URL, target.Proxy, err)
+				continue
+			}
+			target.proxyURL = proxyURL
+		}
+		if target.ContentRegex != "" {
+			pattern, err := regexp.Compile(target.ContentRegex)
+			if err != nil {
+				problem("Skipping URL %q: content_regex = %q: %v", target.URL, target.ContentRegex, err)
+				continue
+			}
+			target.contentPattern = pattern
+		}
+		target.CaptureHeaders = captureHeaderNames(target.CaptureHeaders, target.ExpectHeaders)
+		if len(target.CaptureHeaders) > maxCapturedHeaders {
+			log.Printf("URL %q captures more than %d headers, keeping the first %d", target.URL, maxCapturedHeaders, maxCapturedHeaders)
+			target.CaptureHeaders = target.CaptureHeaders[:maxCapturedHeaders]
+		}
+		if target.BodyLimit < 0 {
+			problem("Skipping URL %q: body_limit = %d: must not be negative", target.URL, target.BodyLimit)
+			continue
+		}
+		if target.BodyLimit == 0 {
+			target.BodyLimit = config.BodyLimit
+		}
+		if !validHeaderValue(target.UserAgent) {
+			problem("Skipping URL %q: user_agent = %q: must not contain control characters", target.URL, target.UserAgent)
+			continue
+		}
+		if target.UserAgent == "" {
+			target.UserAgent = config.UserAgent
+		}
+		if target.MinBodySize < 0 || target.MaxBodySize < 0 || (target.MaxBodySize > 0 && target.MinBodySize > target.MaxBodySize) {
+			problem("Skipping URL %q: min_body_size = %d, max_body_size = %d: must be non-negative and min must not exceed max", target.URL, target.MinBodySize, target.MaxBodySize)
+			continue
+		}
+		if err := prepareHealthRules(target.HealthRules); err != nil {
+			problem("Skipping URL %q: health_rules: %v", target.URL, err)
+			continue
+		}
+		if len(target.HealthRules) > 0 && (len(target.ExpectedStatus) > 0 || target.hasContentCheck() || len(target.ExpectHeaders) > 0) {
+			log.Printf("URL %q has health_rules, ignoring expected_status, content, content_regex and expect_headers", target.URL)
+		}
+		if target.InsecureSkipVerify {
+			log.Printf("WARNING: URL %q skips TLS certificate verification (insecure_skip_verify)", target.URL)
+		}
+		if (target.hasContentCheck() || target.hasSizeCheck() || len(target.HealthRules) > 0) && target.Method == http.MethodHead {
+			// HEAD 沒有回應內容，無法進行內容檢查
+			log.Printf("URL %q has a content check, using GET instead of HEAD", target.URL)
+			target.Method = http.MethodGet
+		}
+		valid = append(valid, target)
+	}
+	config.URLs = valid
+
+	if config.Proxy != "" {
+		if _, err := parseProxy(config.Proxy); err != nil {
+			problem("Ignoring invalid config: proxy = %q: %v", config.Proxy, err)
+			config.Proxy = ""
+		}
+	}
+	return config, problems
+}
+
+// validWebhooks 略過網址或格式無效的 Webhook 設定，field 為設定檔中的欄位名稱
+func validWebhooks(field string, webhooks []WebhookConfig, problem func(format string, args ...any)) []WebhookConfig {
+	var valid []WebhookConfig
+	for i, webhook := range webhooks {
+		u, err := neturl.Parse(webhook.URL)
+		switch {
+		case err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "":
+			problem("Ignoring invalid config: %s[%d].url = %q: must be an http or https URL", field, i, webhook.URL)
+		case webhook.Format != "" && webhook.Format != "json" && webhook.Format != "slack":
+			problem("Ignoring invalid config: %s[%d].format = %q: must be json or slack", field, i, webhook.Format)
+		case webhook.Timeout < 0:
+			problem("Ignoring invalid config: %s[%d].timeout = %v: must not be negative", field, i, webhook.Timeout)
+		default:
+			valid = append(valid, webhook)
+		}
+	}
+	return valid
+}
+
+// applyConfig 套用可以在執行中變更的設定：網址清單、統計範圍、歷史筆數、通知方式等；
+// 這些設定只在持有 statusMu 時讀取，重新載入時呼叫者需持有 statusMu 的寫入鎖
+func applyConfig(config Config) {
+	urls = config.URLs
+	uptimeWindow = config.UptimeWindow
+	statsWindow = config.StatsWindow
+	flapWindow = config.FlapWindow
+	flapThreshold = config.FlapThreshold
+	maxHistory = config.MaxHistory
+	certExpiryWarning = time.Duration(config.CertExpiryWarning)
+	latencyEMAAlpha = config.LatencyEMAAlpha
+	timelineBucket = time.Duration(config.TimelineBucket)
+	histogramBuckets = defaultHistogramBuckets
+	if len(config.HistogramBuckets) > 0 {
+		histogramBuckets = make([]time.Duration, len(config.HistogramBuckets))
+		for i, bound := range config.HistogramBuckets {
+			histogramBuckets[i] = time.Duration(bound)
+		}
+	}
+
+	notifiers = nil
+	if config.Email != nil {
+		notifiers = append(notifiers, NewEmailNotifier(*config.Email))
+	}
+	for _, webhook := range config.Webhooks {
+		notifiers = append(notifiers, NewWebhookNotifier(webhook))
+	}
+	escalationAfter = 0
+	escalationNotifiers = nil
+	if escalation := config.Escalation; escalation != nil && escalation.After > 0 {
+		escalationAfter = time.Duration(escalation.After)
+		if escalation.Email != nil {
+			escalationNotifiers = append(escalationNotifiers, NewEmailNotifier(*escalation.Email))
+		}
+		for _, webhook := range escalation.Webhooks {
+			escalationNotifiers = append(escalationNotifiers, NewWebhookNotifier(webhook))
+		}
+		if len(escalationNotifiers) == 0 {
+			escalationNotifiers = notifiers
+		}
+	}
+}
+
+// restartOnlySettings 檢查協程或伺服器不持有 statusMu 也會讀取的設定，重新載入時不套用
+var restartOnlySettings = []struct {
+	name    string
+	changed func(a, b Config) bool
+}{
+	{"retries", func(a, b Config) bool { return a.Retries != b.Retries }},
+	{"retry_backoff", func(a, b Config) bool { return a.RetryBackoff != b.RetryBackoff }},
+	{"jitter", func(a, b Config) bool { return a.Jitter != b.Jitter || a.JitterEachCheck != b.JitterEachCheck }},
+	{"max_concurrent_checks", func(a, b Config) bool { return a.MaxConcurrent != b.MaxConcurrent }},
+	{"save_interval", func(a, b Config) bool { return a.SaveInterval != b.SaveInterval }},
+	{"proxy", func(a, b Config) bool { return a.Proxy != b.Proxy }},
+	{"status_classes", func(a, b Config) bool { return !reflect.DeepEqual(a.StatusClasses, b.StatusClasses) }},
+	{"redirects_up", func(a, b Config) bool { return a.RedirectsUp != b.RedirectsUp }},
+	{"auth", func(a, b Config) bool { return !reflect.DeepEqual(a.Auth, b.Auth) }},
+}
+
+// reloadConfig 重新讀取設定檔並完整驗證，任何網址或設定無效時拒絕整份設定、維持目前的設定；
+// 通過驗證後啟動新增的網址、停止移除的網址、以新設定重新啟動變更的網址，其餘網址的歷史與狀態不受影響。
+// 返回實際生效的設定，需要重新啟動才能套用的項目保留 previous 的值
+func reloadConfig(path string, previous Config, monitors *monitorSet) (Config, error) {
+	config, err := readConfigFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) || os.Getenv(envURLs) == "" {
+			return previous, err
+		}
+		config = Config{}
+	}
+	if err := applyEnvConfig(&config); err != nil {
+		return previous, err
+	}
+	config, problems := normalizeConfig(config)
+	if len(problems) > 0 {
+		return previous, fmt.Errorf("%d invalid setting(s), see the log above", len(problems))
+	}
+
+	var restart []string
+	for _, setting := range restartOnlySettings {
+		if setting.changed(previous, config) {
+			restart = append(restart, setting.name)
+		}
+	}
+	config.Retries, config.RetryBackoff, config.MaxConcurrent = previous.Retries, previous.RetryBackoff, previous.MaxConcurrent
+	config.SaveInterval, config.Proxy = previous.SaveInterval, previous.Proxy
+	config.StatusClasses, config.Auth = previous.StatusClasses, previous.Auth
+	config.Jitter, config.JitterEachCheck, config.RedirectsUp = previous.Jitter, previous.JitterEachCheck, previous.RedirectsUp
+	mergeRemoteURLs(&config)
+	if len(config.URLs) == 0 {
+		return previous, errors.New("no URLs to monitor")
+	}
+
+	added, removed, changed := applyTargets(config, monitors)
+	log.Printf("Reloaded config from %s: %d added, %d removed, %d changed", path, len(added), len(removed), len(changed))
+	logTargetChanges(added, removed, changed)
+	if len(restart) > 0 {
+		log.Printf("Settings %s changed but require a restart to take effect", strings.Join(restart, ", "))
+	}
+	return config, nil
+}
+
+// refreshRemoteURLs 重新取得遠端網址清單並替換 active 中來自遠端的網址，不重新讀取設定檔；
+// 返回更新後的設定，清單沒有變更時不記錄日誌
+func refreshRemoteURLs(active Config, monitors *monitorSet) Config {
+	config := active
+	config.URLs = slices.Clone(active.URLs[:len(active.URLs)-active.remoteCount])
+	mergeRemoteURLs(&config)
+	if len(config.URLs) == 0 {
+		return active
+	}
+
+	added, removed, changed := applyTargets(config, monitors)
+	if len(added)+len(removed)+len(changed) == 0 {
+		debugf("Remote URL list from %s unchanged", config.RemoteURLs.URL)
+		return config
+	}
+	log.Printf("Refreshed remote URL list from %s: %d added, %d removed, %d changed", config.RemoteURLs.URL, len(added), len(removed), len(changed))
+	logTargetChanges(added, removed, changed)
+	return config
+}
+
+// applyTargets 套用設定並讓執行中的協程符合 config.URLs，移除的網址一併清除狀態與歷史；
+// 返回新增、移除與變更的網址
+func applyTargets(config Config, monitors *monitorSet) (added, removed, changed []string) {
+	statusMu.Lock()
+	applyConfig(config)
+	statusMu.Unlock()
+
+	added, removed, changed = monitors.apply(config.URLs)
+	statusMu.Lock()
+	for _, url := range removed {
+		// 移除的網址不再顯示，下一次保存時也不再寫入歷史檔案
+		delete(currentStatus, url)
+		delete(metrics, url)
+		delete(alertCooldowns, url)
+		cancelEscalation(url)
+		historyDirty = true
+	}
+	statusMu.Unlock()
+	seedPendingStatuses(config.URLs)
+	return added, removed, changed
+}
+
+// logTargetChanges 在日誌中列出新增、移除與變更的網址
+func logTargetChanges(added, removed, changed []string) {
+	for _, change := range []struct {
+		label string
+		urls  []string
+	}{{"Added", added}, {"Removed", removed}, {"Changed", changed}} {
+		if len(change.urls) > 0 {
+			log.Printf("%s: %s", change.label, strings.Join(change.urls, ", "))
+		}
+	}
+}
+
+// 可以取代設定檔的環境變數
+const (
+	envURLs     = "WEBSITE_MONITOR_URLS"
+	envInterval = "WEBSITE_MONITOR_INTERVAL"
+)
+
+// applyEnvConfig 以環境變數取代設定檔中的對應設定，未設定或為空的環境變數不影響設定檔
+// WEBSITE_MONITOR_URLS 可以是逗號分隔的網址，或與設定檔 urls 相同格式的 JSON 陣列
+func applyEnvConfig(config *Config) error {
+	if value := strings.TrimSpace(os.Getenv(envURLs)); value != "" {
+		var targets []URLConfig
+		if strings.HasPrefix(value, "[") {
+			if err := json.Unmarshal([]byte(value), &targets); err != nil {
+				return fmt.Errorf("%s: %w", envURLs, err)
+			}
+		} else {
+			for _, rawURL := range strings.Split(value, ",") {
+				if rawURL = strings.TrimSpace(rawURL); rawURL != "" {
+					targets = append(targets, URLConfig{URL: rawURL})
+				}
+			}
+		}
+		config.URLs = targets
+	}
+	if value := os.Getenv(envInterval); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", envInterval, err)
+		}
+		config.Interval = Duration(interval)
+	}
+	return nil
+}
+
+// lastRemoteURLs 最後一次成功取得的遠端網址清單，取得失敗時沿用；只在載入設定的協程中使用
+var lastRemoteURLs []URLConfig
+
+// mergeRemoteURLs 取得設定的遠端網址清單並加在已驗證的 config.URLs 之後，設定檔中已有的網址以設定檔為準；
+// 遠端的網址沿用 config 的全域預設值，任何項目無效時拒絕整份清單。
+// 取得失敗或清單無效時記錄日誌並沿用最後一次成功取得的清單
+func mergeRemoteURLs(config *Config) {
+	config.remoteCount = 0
+	remote := config.RemoteURLs
+	if remote == nil {
+		lastRemoteURLs = nil
+		return
+	}
+	targets, err := fetchRemoteURLs(*remote, config.Proxy)
+	if err == nil {
+		defaults := Config{Interval: config.Interval, AlertCooldown: config.AlertCooldown, BodyLimit: config.BodyLimit, UserAgent: config.UserAgent, URLs: targets}
+		normalized, problems := normalizeConfig(defaults)
+		if len(problems) > 0 {
+			err = fmt.Errorf("invalid remote URL list: %s", strings.Join(problems, "; "))
+		}
+		targets = normalized.URLs
+	}
+	if err != nil {
+		log.Printf("Fetching remote URL list from %s failed, using the last good list (%d URLs): %v", remote.URL, len(lastRemoteURLs), err)
+		targets = lastRemoteURLs
+	} else {
+		lastRemoteURLs = targets
+	}
+
+	existing := make(map[string]bool, len(config.URLs))
+	for _, target := range config.URLs {
+		existing[target.URL] = true
+	}
+	added := 0
+	for _, target := range targets {
+		if !existing[target.URL] {
+			existing[target.URL] = true
+			config.URLs = append(config.URLs, target)
+			added++
+		}
+	}
+	config.remoteCount = added
+	debugf("Merged %d URLs from remote list %s (%d already in the config file)", added, remote.URL, len(targets)-added)
+}
+
+// fetchRemoteURLs 從遠端端點取得尚未驗證的網址清單；
+// 遠端的內容不展開 ${VAR}，避免清單的提供者取得本機的環境變數
+func fetchRemoteURLs(remote RemoteURLsConfig, proxy string) ([]URLConfig, error) {
+	u, err := neturl.Parse(remote.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("remote_urls.url %q must be an http or https URL", remote.URL)
+	}
+	// 載入設定時全域 proxy 還沒寫入環境變數，不能使用 http.DefaultClient：
+	// http.ProxyFromEnvironment 只在第一次呼叫時讀取環境變數，之後所有檢查都會忽略全域 proxy
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		proxyURL, err := parseProxy(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %q: %w", redactURL(proxy), err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+
+	ctx, cancel := context.WithTimeout(context.Background(), httpClient.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, remote.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent())
+	req.Header.Set("Accept", "application/json")
+	for name, value := range remote.Headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer drainAndClose(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteListBytes))
+	if err != nil {
+		return nil, fmt.Errorf("reading remote URL list: %w", err)
+	}
+
+	// 接受 [...] 或 {"urls": [...]} 兩種格式
+	var targets []URLConfig
+	if err := json.Unmarshal(data, &targets); err != nil {
+		var wrapped struct {
+			URLs []URLConfig `json:"urls"`
+		}
+		if wrappedErr := json.Unmarshal(data, &wrapped); wrappedErr != nil {
+			return nil, fmt.Errorf("decoding remote URL list: %v", describeDecodeError(err))
+		}
+		targets = wrapped.URLs
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("remote URL list is empty")
+	}
+	return targets, nil
+}
+
+// parseProxy 解析代理伺服器網址，支援 http、https 與 socks5
+func parseProxy(rawURL string) (*neturl.URL, error) {
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" {
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("missing proxy host")
+	}
+	return u, nil
+}
+
+// 記錄回應標頭的上限，避免歷史檔案與頁面因標頭過多或過長而膨脹
+const (
+	maxCapturedHeaders   = 20
+	maxHeaderValueLength = 256
+)
+
+// captureHeaderNames 合併 capture_headers 與 expect_headers 的標頭名稱，
+// 轉為標準寫法並去除重複，expect_headers 的名稱依字母順序加在後面
+func captureHeaderNames(capture []string, expect map[string]string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, name := range capture {
+		add(name)
+	}
+	expectNames := make([]string, 0, len(expect))
+	for name := range expect {
+		expectNames = append(expectNames, name)
+	}
+	sort.Strings(expectNames)
+	for _, name := range expectNames {
+		add(name)
+	}
+	return names
+}
+
+// captureHeaders 取出指定的回應標頭，多個值以逗號合併，過長的值截斷；沒有出現的標頭不記錄
+func captureHeaders(header http.Header, names []string) map[string]string {
+	if len(names) == 0 {
+		return nil
+	}
+	captured := make(map[string]string)
+	for _, name := range names {
+		values := header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		value := strings.Join(values, ", ")
+		if len(value) > maxHeaderValueLength {
+			value = value[:maxHeaderValueLength] + "…"
+		}
+		captured[name] = value
+	}
+	return captured
+}
+
+// headersMatch 檢查回應標頭是否存在且包含設定的值
+func headersMatch(header http.Header, expect map[string]string) bool {
+	for name, want := range expect {
+		values := header.Values(name)
+		if len(values) == 0 || !strings.Contains(strings.Join(values, ", "), want) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkMethods 檢查可使用的 HTTP 方法，值代表該方法是否可以帶有請求內容
+var checkMethods = map[string]bool{
+	http.MethodGet:   false,
+	http.MethodHead:  false,
+	http.MethodPost:  true,
+	http.MethodPut:   true,
+	http.MethodPatch: true,
+}
+
+// validStatusCodes 檢查清單中的每個值都是 HTTP 狀態碼
+func validStatusCodes(codes []int) bool {
+	for _, code := range codes {
+		if code < 100 || code > 599 {
+			return false
+		}
+	}
+	return true
+}
+
+// prepareHealthRules 檢查每條正常條件，補上預設名稱並編譯正規表示式
+// 群組的網址共用同一份規則，因此直接修改也會作用在同群組的其他網址上，結果相同
+func prepareHealthRules(rules []HealthRule) error {
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if len(rule.Status) == 0 || !validStatusCodes(rule.Status) {
+			return fmt.Errorf("%s: status must list HTTP status codes (100-599)", rule.Name)
+		}
+		if rule.ContentRegex != "" {
+			pattern, err := regexp.Compile(rule.ContentRegex)
+			if err != nil {
+				return fmt.Errorf("%s: invalid content_regex: %w", rule.Name, err)
+			}
+			rule.contentPattern = pattern
+		}
+	}
+	return nil
+}
+
+// parseMaintenance 依序解析網址的所有維護時段
+func parseMaintenance(windows []MaintenanceWindow) error {
+	for i := range windows {
+		if err := windows[i].parse(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readConfigFile 讀取並解析設定檔
+func readConfigFile(path string) (Config, error) {
+	var config Config
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, decodeError(path, data, err)
+	}
+	config.URLs = append(config.URLs, flattenGroups(config.Groups)...)
+	config.Groups = nil
+	expandConfigEnv(&config)
+	return config, nil
+}
+
+// decodeError 在 JSON 語法錯誤加上行號與欄位，其餘錯誤加上設定錯誤的欄位名稱，
+// 方便找到設定檔中打錯的地方
+func decodeError(path string, data []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line, column := position(data, syntaxErr.Offset)
+		return fmt.Errorf("%s:%d:%d: invalid JSON: %v", path, line, column, syntaxErr)
+	}
+	return fmt.Errorf("%s: %v", path, describeDecodeError(err))
+}
+
+// describeDecodeError 將型別錯誤改寫成「欄位: 實際的值與應有的型別」
+func describeDecodeError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) || typeErr.Field == "" {
+		return err
+	}
+	want := typeErr.Type.String()
+	if typeErr.Type == reflect.TypeFor[Duration]() {
+		want = `a duration string like "10s"`
+	}
+	return fmt.Errorf("%s: got a JSON %s, want %s", typeErr.Field, typeErr.Value, want)
+}
+
+// position 將位元組位移換算成從 1 開始的行號與欄位
+func position(data []byte, offset int64) (line, column int) {
+	offset = min(offset, int64(len(data)))
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	column = int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+// envPattern 設定檔中的環境變數寫法，只接受 ${VAR}
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv 將字串中的 ${VAR} 替換為環境變數的值，其餘文字保持原樣
+// 不使用 os.ExpandEnv，避免密碼等欄位中單獨的 $ 被誤當成變數
+func expandEnv(value string) string {
+	return envPattern.ReplaceAllStringFunc(value, func(match string) string {
+		name := envPattern.FindStringSubmatch(match)[1]
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			log.Printf("Environment variable %s referenced in config is not set", name)
+		}
+		return env
+	})
+}
+
+// expandNotifyEnv 展開郵件與 Webhook 通知設定中的環境變數
+func expandNotifyEnv(email *EmailConfig, webhooks []WebhookConfig) {
+	if email != nil {
+		email.Host = expandEnv(email.Host)
+		email.Username = expandEnv(email.Username)
+		email.Password = expandEnv(email.Password)
+		email.From = expandEnv(email.From)
+		for i, to := range email.To {
+			email.To[i] = expandEnv(to)
+		}
+	}
+	for i := range webhooks {
+		webhooks[i].URL = expandEnv(webhooks[i].URL)
+	}
+}
+
+// expandConfigEnv 展開設定檔中網址、標頭、驗證資訊與通知設定的環境變數
+func expandConfigEnv(config *Config) {
+	for i := range config.URLs {
+		target := &config.URLs[i]
+		target.URL = expandEnv(target.URL)
+		target.BearerToken = expandEnv(target.BearerToken)
+		for name, value := range target.Headers {
+			target.Headers[name] = expandEnv(value)
+		}
+		if target.BasicAuth != nil {
+			target.BasicAuth.Username = expandEnv(target.BasicAuth.Username)
+			target.BasicAuth.Password = expandEnv(target.BasicAuth.Password)
+		}
+	}
+	if remote := config.RemoteURLs; remote != nil {
+		remote.URL = expandEnv(remote.URL)
+		for name, value := range remote.Headers {
+			remote.Headers[name] = expandEnv(value)
+		}
+	}
+	expandNotifyEnv(config.Email, config.Webhooks)
+	if escalation := config.Escalation; escalation != nil {
+		expandNotifyEnv(escalation.Email, escalation.Webhooks)
+	}
+	if auth := config.Auth; auth != nil {
+		auth.Username = expandEnv(auth.Username)
+		auth.Password = expandEnv(auth.Password)
+		auth.PasswordSHA256 = expandEnv(auth.PasswordSHA256)
+	}
+}
+
+// validateURL 檢查網址是否為合法的 http、https、tcp 或 ping 網址
+func validateURL(rawURL string) error {
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "tcp" && u.Scheme != "ping" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return errors.New("missing host")
+	}
+	if u.Scheme == "tcp" && u.Port() == "" {
+		return errors.New("tcp URL must include a port")
+	}
+	return nil
+}
+
+// 檢查方式
+const (
+	kindHTTP = "http" // HTTP/HTTPS 請求
+	kindTCP  = "tcp"  // 只建立 TCP 連線
+	kindPing = "ping" // ICMP echo，不允許時改用 TCP 連線
+)
+
+// checkKind 依網址的 scheme 決定檢查方式，tcp:// 為 TCP 連線檢查，ping:// 為 ping，其餘為 HTTP
+func checkKind(rawURL string) string {
+	lower := strings.ToLower(rawURL)
+	switch {
+	case strings.HasPrefix(lower, "tcp://"):
+		return kindTCP
+	case strings.HasPrefix(lower, "ping://"):
+		return kindPing
+	}
+	return kindHTTP
+}
+
+// statusText 根據狀態碼返回狀態碼的解釋
+func statusText(code int) string {
+	switch code {
+	case 200:
+		return "OK"
+	case 301:
+		return "Moved Permanently"
+	case 302:
+		return "Found"
+	case 400:
+		return "Bad Request"
+	case 401:
+		return "Unauthorized"
+	case 403:
+		return "Forbidden"
+	case 404:
+		return "Not Found"
+	case 500:
+		return "Internal Server Error"
+	case 502:
+		return "Bad Gateway"
+	case 503:
+		return "Service Unavailable"
+	case 0:
+		return "Connection Error" // 0 代表連線失敗，並非真正的 HTTP 狀態碼
+	}
+
+	// 其餘標準狀態碼交由 http.StatusText 提供標準說明
+	if text := http.StatusText(code); text != "" {
+		return text
+	}
+	return "Unknown Status"
+}
+
+// WebsiteStatus 網站狀態結構
+type WebsiteStatus struct {
+	URL              string
+	Name             string   // 設定的顯示名稱，未設定時為空字串，可用 displayName 取得顯示用的名稱
+	Group            string   // 網址所屬的群組，未設定群組時為空字串
+	Tags             []string // 設定的標籤
+	Critical         bool     // 設定為重要的網址，異常時 /healthz 返回 503
+	MatchedRule      string   // 最近一次檢查符合的 health_rules 規則名稱
+	Status           int
+	StatusMessage    string
+	LastChecked      time.Time
+	LastSeenUp       time.Time         // 最近一次檢查正常的時間，從未正常過時為零值
+	DownSince        time.Time         // 這次異常開始的時間，正常時為零值
+	ResponseTime     time.Duration     // 包含讀取回應內容的總時間
+	TTFB             time.Duration     // 收到第一個回應位元組的時間
+	Method           string            // 最近一次檢查實際使用的 HTTP 方法
+	Kind             string            // 檢查方式：http、tcp 或 ping
+	PacketLoss       float64           // ping 檢查最近一次未收到回覆的比例，0 到 1
+	CheckFailed      bool              // 最近一次檢查未通過內容檢查或狀態碼不符預期
+	Expected         bool              // 最近一次的狀態碼不在 2xx 但符合網址設定的 expected_status
+	ErrorKind        string            // 最近一次連線失敗的原因，檢查成功時為空字串
+	State            string            // 狀態分類：ok、warning、error、degraded、maintenance、pending
+	Maintenance      bool              // 最近一次檢查時處於維護時段
+	ContentLength    int64             // 最近一次回應的 Content-Length，-1 代表未知
+	ContentType      string            // 最近一次回應的 Content-Type
+	ContentEncoding  string            // 最近一次回應的 Content-Encoding
+	WireSize         int64             // 最近一次實際傳輸的回應內容位元組數 (壓縮後)
+	BodySize         int64             // 最近一次解壓縮後的回應內容位元組數
+	Truncated        bool              // 最近一次的回應內容超過 body_limit 而被截斷
+	Headers          map[string]string // 最近一次依 capture_headers 記錄的回應標頭
+	RemoteIP         string            // 最近一次檢查實際連線的 IP
+	DNSTime          time.Duration     // 最近一次檢查的 DNS 查詢時間，重複使用連線時為 0
+	ConnectTime      time.Duration     // 最近一次檢查的 TCP 連線時間
+	TLSTime          time.Duration     // 最近一次檢查的 TLS 交握時間，非 TLS 的網址為 0
+	IPChanged        bool              // 最近一次連線的 IP 與前一次不同
+	PreviousIP       string            // IPChanged 時前一次連線的 IP
+	ContentHash      string            // 設定 detect_content_change 時最近一次正常檢查的回應內容 SHA-256 (hex)
+	ContentChanged   bool              // 最近一次檢查的內容雜湊與前一次正常檢查不同
+	ContentChangedAt time.Time         // 最近一次偵測到內容變更的時間，從未變更時為零值
+	Probes           []ProbeResult     // 設定 probes 時各探測點最近一次的結果
+	KnownIPs         []string          // 最近連線過的 IP，依時間排列，最後一個為最近一次
+	Proto            string            // 最近一次回應使用的通訊協定
+	ConnReused       bool              // 最近一次檢查是否重複使用了先前的連線
+	FinalURL         string            // 跟隨重新導向後最終的網址
+	RedirectHops     int               // 經過的重新導向次數
+	CertExpiry       time.Time         // https 憑證的到期時間，http 網址為零值
+	DaysUntilExpiry  int               // 距離憑證到期的天數
+	CertExpiringSoon bool              // 憑證是否即將在警告門檻內到期
+	Flapping         bool              // 最近是否在正常與異常之間頻繁切換
+	Uptime           float64           // 正常運作百分比 (0-100)
+	UptimeWindows    []WindowUptime    // 最近 24 小時、7 天、30 天的正常運作百分比
+	AvgResponseTime  time.Duration     // 最近檢查的平均回應時間
+	LatencyEMA       time.Duration     // 回應時間的指數移動平均，不計連線錯誤
+	MinResponseTime  time.Duration     // 最近檢查的最短回應時間
+	MaxResponseTime  time.Duration     // 最近檢查的最長回應時間
+	Histogram        []HistogramBucket // 最近檢查的回應時間分佈
+	Timeline         []TimelineBucket  // 依 timeline_bucket 分格的歷史狀態時間軸
+	HistoryStatuses  []HistoryStatus   // 歷史狀態紀錄
+}
+
+// Healthy 判斷網站目前是否正常
+func (s WebsiteStatus) Healthy() bool {
+	return (isUp(s.Status) || s.Expected) && !s.CheckFailed
+}
+
+// displayName 返回顯示用的名稱，未設定 name 時為網址
+func (s WebsiteStatus) displayName() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.URL
+}
+
+// HistoryStatus 用於記錄歷史狀態的結構
+type HistoryStatus struct {
+	Status         int
+	StatusMessage  string
+	CheckedTime    time.Time
+	ResponseTime   time.Duration
+	TTFB           time.Duration `json:",omitempty"` // 收到第一個回應位元組的時間
+	CheckFailed    bool          `json:",omitempty"` // 未通過內容檢查或狀態碼不符預期
+	Expected       bool          `json:",omitempty"` // 狀態碼不在 2xx 但符合網址設定的 expected_status
+	ErrorKind      string        `json:",omitempty"` // 連線失敗的原因：dns、refused、timeout、tls 或 connection
+	ContentLength  int64         `json:",omitempty"` // 回應的 Content-Length，-1 代表未知
+	ContentType    string        `json:",omitempty"` // 回應的 Content-Type
+	ChangedContent bool          `json:",omitempty"` // 回應內容與前一次正常檢查不同
+}
+
+// Healthy 判斷這筆紀錄是否代表網站正常
+func (h HistoryStatus) Healthy() bool {
+	return (isUp(h.Status) || h.Expected) && !h.CheckFailed
+}
+
+// historyTimeFormat 歷史紀錄時間的 JSON 格式，固定為 UTC 與毫秒精度，例如 2024-01-02T15:04:05.123Z
+const historyTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// historyStatusJSON HistoryStatus 在 JSON 中的格式，方便 JavaScript 等外部程式直接使用：
+// 時間為毫秒精度的 ISO 8601 字串，回應時間為整數毫秒
+type historyStatusJSON struct {
+	Status         int
+	StatusMessage  string
+	CheckedTime    string
+	ResponseTimeMs int64
+	TTFBMs         int64  `json:",omitempty"`
+	CheckFailed    bool   `json:",omitempty"`
+	Expected       bool   `json:",omitempty"`
+	ErrorKind      string `json:",omitempty"`
+	ContentLength  int64  `json:",omitempty"`
+	ContentType    string `json:",omitempty"`
+	ChangedContent bool   `json:",omitempty"`
+
+	// 歷史檔案版本 3 之前以奈秒整數記錄的回應時間，只在讀取舊資料時使用
+	ResponseTime *time.Duration `json:",omitempty"`
+	TTFB         time.Duration  `json:",omitempty"`
+}
+
+// MarshalJSON 以毫秒精度輸出時間與回應時間，寫入後再讀取的結果與寫入前相同
+func (h HistoryStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(historyStatusJSON{
+		Status:         h.Status,
+		StatusMessage:  h.StatusMessage,
+		CheckedTime:    h.CheckedTime.UTC().Format(historyTimeFormat),
+		ResponseTimeMs: h.ResponseTime.Milliseconds(),
+		TTFBMs:         h.TTFB.Milliseconds(),
+		CheckFailed:    h.CheckFailed,
+		Expected:       h.Expected,
+		ErrorKind:      h.ErrorKind,
+		ContentLength:  h.ContentLength,
+		ContentType:    h.ContentType,
+		ChangedContent: h.ChangedContent,
+	})
+}
+
+// UnmarshalJSON 讀取毫秒格式，也接受舊版以 RFC3339Nano 時間與奈秒回應時間記錄的紀錄
+func (h *HistoryStatus) UnmarshalJSON(data []byte) error {
+	var raw historyStatusJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	checked, err := time.Parse(time.RFC3339Nano, raw.CheckedTime)
+	if err != nil {
+		return fmt.Errorf("decoding CheckedTime: %w", err)
+	}
+	*h = HistoryStatus{
+		Status:         raw.Status,
+		StatusMessage:  raw.StatusMessage,
+		CheckedTime:    checked,
+		ResponseTime:   time.Duration(raw.ResponseTimeMs) * time.Millisecond,
+		TTFB:           time.Duration(raw.TTFBMs) * time.Millisecond,
+		CheckFailed:    raw.CheckFailed,
+		Expected:       raw.Expected,
+		ErrorKind:      raw.ErrorKind,
+		ContentLength:  raw.ContentLength,
+		ContentType:    raw.ContentType,
+		ChangedContent: raw.ChangedContent,
+	}
+	if raw.ResponseTime != nil {
+		h.ResponseTime = *raw.ResponseTime
+		h.TTFB = raw.TTFB
+	}
+	return nil
+}
+
+// httpClient 用於檢查網站的 HTTP 客戶端，逾時時間於啟動時設定
+var httpClient = &http.Client{
+	Timeout:       defaultTimeout,
+	Transport:     newCheckTransport(),
+	CheckRedirect: checkRedirect,
+}
+
+// 變數，以存放目前網站狀態
+var currentStatus = make(map[string]WebsiteStatus)
+
+// statusMu 保護 currentStatus 與 historyDirty，寫入時取得寫入鎖，讀取時取得讀取鎖
+var statusMu sync.RWMutex
+
+// historyDirty 標記 currentStatus 自上次保存後是否有變更
+var historyDirty bool
+
+// lastCheckTime 最近一次完成檢查的時間（任何網址），供 /healthz 判斷監控是否仍在運作
+var lastCheckTime time.Time
+
+// processStart 程式啟動時間
+var processStart = time.Now()
+
+// urlMetrics 單一網址的 Prometheus 指標
+type urlMetrics struct {
+	LastStatus      int
+	Up              bool
+	ResponseSeconds float64
+	Checks          uint64 // 本次執行以來的檢查次數
+}
+
+// metrics 每個網址的指標，與 currentStatus 一樣受 statusMu 保護
+var metrics = make(map[string]*urlMetrics)
+
+// monitorSet 執行中的檢查協程，每個網址各自在獨立的協程中檢查，互不影響；
+// 依網址記錄，重新載入設定時可以個別停止或重新啟動
+type monitorSet struct {
+	ctx     context.Context
+	wg      *sync.WaitGroup
+	mu      sync.Mutex
+	running map[string]*urlMonitor
+}
+
+// urlMonitor 同一個網址的檢查協程，網址在設定中出現多次時共用同一個 cancel
+type urlMonitor struct {
+	targets []URLConfig
+	cancel  context.CancelFunc
+}
+
+// newMonitorSet 建立檢查協程的集合，ctx 取消後所有檢查協程會停止，wg 用於等待它們結束
+func newMonitorSet(ctx context.Context, wg *sync.WaitGroup) *monitorSet {
+	return &monitorSet{ctx: ctx, wg: wg, running: make(map[string]*urlMonitor)}
+}
+
+// apply 讓執行中的協程符合新的網址清單：啟動新增的網址、停止移除的網址，
+// 設定有變更的網址停止後以新設定重新啟動；返回新增、移除與變更的網址
+func (m *monitorSet) apply(targets []URLConfig) (added, removed, changed []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	byURL := make(map[string][]URLConfig)
+	var order []string
+	for _, target := range targets {
+		if _, ok := byURL[target.URL]; !ok {
+			order = append(order, target.URL)
+		}
+		byURL[target.URL] = append(byURL[target.URL], target)
+	}
+
+	for url, running := range m.running {
+		if _, ok := byURL[url]; !ok {
+			running.cancel()
+			delete(m.running, url)
+			removed = append(removed, url)
+		}
+	}
+	sort.Strings(removed)
+	for _, url := range order {
+		running, ok := m.running[url]
+		switch {
+		case !ok:
+			added = append(added, url)
+		case !sameTargets(running.targets, byURL[url]):
+			running.cancel()
+			changed = append(changed, url)
+		default:
+			continue
+		}
+		m.start(url, byURL[url])
+	}
+	return added, removed, changed
+}
+
+// start 為網址的每個設定啟動一個檢查協程，呼叫者需持有 m.mu
+func (m *monitorSet) start(url string, targets []URLConfig) {
+	ctx, cancel := context.WithCancel(m.ctx)
+	m.running[url] = &urlMonitor{targets: targets, cancel: cancel}
+	for _, target := range targets {
+		m.wg.Add(1)
+		go func(target URLConfig) {
+			defer m.wg.Done()
+			monitorWebsite(ctx, target)
+		}(target)
+	}
+}
+
+// sameTargets 比較兩組網址設定是否相同，Group 不會寫入 JSON，需另外比較
+func sameTargets(a, b []URLConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		left, _ := json.Marshal(a[i])
+		right, _ := json.Marshal(b[i])
+		if a[i].Group != b[i].Group || !bytes.Equal(left, right) {
+			return false
+		}
+	}
+	return true
+}
+
+// 依照該網址的間隔時間持續檢查，直到 ctx 被取消
+func monitorWebsite(ctx context.Context, target URLConfig) {
+	// 第一次檢查前隨機延遲，避免所有網址在啟動時同時檢查、之後也一直同時檢查
+	interval := time.Duration(target.Interval)
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(jitter(interval)):
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		checkWebsite(ctx, target)
+		if jitterEachCheck {
+			// 下次檢查在 interval 前後隨機的時間，平均仍為 interval
+			ticker.Reset(interval - time.Duration(float64(interval)*jitterFraction/2) + jitter(interval))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// jitter 返回 0 到 interval 的 jitterFraction 倍之間的隨機時間
+func jitter(interval time.Duration) time.Duration {
+	limit := int64(float64(interval) * jitterFraction)
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(limit))
+}
+
+// 檢查單一網址一次並更新狀態
+func checkWebsite(ctx context.Context, target URLConfig) {
+	result, err := checkWithRetries(ctx, target)
+	if ctx.Err() != nil && errors.Is(err, ctx.Err()) {
+		// 關閉途中被中斷的檢查不記錄
+		return
+	}
+
+	entry := updateStatus(target.URL, result)
+	if err := store.Record(target.URL, entry); err != nil {
+		log.Printf("Error recording history: %v", err)
+	}
+
+	logCheckResult(target.URL, result, err)
+}
+
+// checkWithRetries 檢查網址一次並填入來自設定的欄位，設定了 probes 時由每個探測點各自檢查後合併結果
+func checkWithRetries(ctx context.Context, target URLConfig) (result CheckResult, err error) {
+	if len(target.Probes) > 0 {
+		result, err = checkProbes(ctx, target)
+	} else {
+		result, err = retryCheck(ctx, target)
+	}
+
+	result.DegradedThreshold = time.Duration(target.DegradedThreshold)
+	result.AlertCooldown = time.Duration(target.AlertCooldown)
+	result.Maintenance = target.inMaintenance(result.CheckedTime)
+	result.Group = target.Group
+	result.Name = target.Name
+	result.Critical = target.Critical
+	result.Tags = target.Tags
+	result.AlertOnIPChange = target.AlertOnIPChange
+	return result, err
+}
+
+// ProbeResult 一個探測點最近一次檢查的結果
+type ProbeResult struct {
+	Name          string
+	Status        int
+	StatusMessage string
+	Healthy       bool
+	ResponseTime  time.Duration
+	ErrorKind     string `json:",omitempty"`
+	RemoteIP      string `json:",omitempty"`
+}
+
+// checkProbes 同時以每個探測點檢查網址 (各自重試)：至少一個探測點正常時以第一個正常的結果為代表，
+// 全部失敗時以第一個探測點的結果為代表；各探測點的結果記錄在 Probes
+func checkProbes(ctx context.Context, target URLConfig) (CheckResult, error) {
+	results := make([]CheckResult, len(target.Probes))
+	errs := make([]error, len(target.Probes))
+	var wg sync.WaitGroup
+	for i, probe := range target.Probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = retryCheck(ctx, target.forProbe(probe))
+		}()
+	}
+	wg.Wait()
+
+	probes := make([]ProbeResult, len(results))
+	primary, failed := -1, 0
+	for i, r := range results {
+		probes[i] = ProbeResult{
+			Name:          target.Probes[i].Name,
+			Status:        r.Status,
+			StatusMessage: r.StatusMessage,
+			Healthy:       r.Healthy(),
+			ResponseTime:  r.ResponseTime,
+			ErrorKind:     r.ErrorKind,
+			RemoteIP:      r.RemoteIP,
+		}
+		if !r.Healthy() {
+			failed++
+		} else if primary < 0 {
+			primary = i
+		}
+		if ctx.Err() != nil && errors.Is(errs[i], ctx.Err()) {
+			return r, errs[i]
+		}
+	}
+	if primary < 0 {
+		primary = 0
+	}
+
+	result, err := results[primary], errs[primary]
+	result.Probes = probes
+	result.ProbesFailed = failed
+	switch {
+	case failed == len(results):
+		result.StatusMessage += fmt.Sprintf(" (All %d Probes Failed)", failed)
+	case failed > 0:
+		result.StatusMessage += fmt.Sprintf(" (%d/%d Probes Failed)", failed, len(results))
+		debugf("%s: %d of %d probes failed", target.URL, failed, len(results))
+	}
+	return result, err
+}
+
+// retryCheck 檢查網址一次，連線錯誤或 5xx 時依設定以指數退避重試，
+// 返回最後一次嘗試的結果；ctx 在等待重試時被取消則返回 ctx.Err()
+func retryCheck(ctx context.Context, target URLConfig) (result CheckResult, err error) {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		// 等待空出的檢查名額，重試前的等待期間不佔用名額
+		select {
+		case checkSlots <- struct{}{}:
+		case <-ctx.Done():
+			return result, ctx.Err()
+		}
+		result, err = performCheck(ctx, target)
+		<-checkSlots
+		if !shouldRetry(result) || attempt >= maxRetries {
+			break
+		}
+
+		debugf("Attempt %d for %s failed (%s), retrying in %v", attempt+1, target.URL, result.StatusMessage, backoff)
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return result, err
+}
+
+// runCheckMode 依序檢查每個網址一次並將結果表格印到標準輸出，
+// 不啟動伺服器也不啟動背景協程；任何網址異常時返回非零的結束碼
+func runCheckMode(targets []URLConfig) int {
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "RESULT\tSTATUS\tMESSAGE\tRESPONSE TIME\tURL")
+
+	exitCode := 0
+	for _, target := range targets {
+		result, err := checkWithRetries(context.Background(), target)
+		logCheckResult(target.URL, result, err)
+
+		verdict := "UP"
+		if !result.Healthy() {
+			verdict = "DOWN"
+			exitCode = 1
+		}
+		fmt.Fprintf(table, "%s\t%d\t%s\t%v\t%s\n", verdict, result.Status, result.StatusMessage, result.ResponseTime.Round(time.Millisecond), target.URL)
+	}
+	table.Flush()
+	return exitCode
+}
+
+// runTerminalView 每隔 every 以 ANSI 控制碼清除終端機畫面，重新印出所有網址目前狀態的表格，
+// 與網頁伺服器同時執行，直到 ctx 被取消
+func runTerminalView(ctx context.Context, addr string, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		renderTerminal(os.Stdout, addr, snapshotStatuses())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// renderTerminal 將狀態表格寫入 w，開頭的 "\033[H\033[2J" 將游標移到左上角並清除畫面
+func renderTerminal(w io.Writer, addr string, statuses []WebsiteStatus) {
+	summary := summarize(statuses)
+	fmt.Fprint(w, "\033[H\033[2J")
+	fmt.Fprintf(w, "Website Monitor on %s - %s\n", addr, time.Now().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(w, "%d up, %d degraded, %d redirect, %d warning, %d down, %d maintenance, %d pending of %d URLs\n\n",
+		summary.Up, summary.Degraded, summary.Redirect, summary.Warning, summary.Down, summary.Maintenance, summary.Pending, summary.Total)
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "STATE\tSTATUS\tMESSAGE\tRESPONSE TIME\tUPTIME\tLAST CHECKED\tSITE")
+	for _, status := range statuses {
+		checked := "never"
+		if !status.LastChecked.IsZero() {
+			checked = status.LastChecked.Format("15:04:05")
+		}
+		fmt.Fprintf(table, "%s\t%d\t%s\t%v\t%.2f%%\t%s\t%s\n", status.State, status.Status, status.StatusMessage,
+			status.ResponseTime.Round(time.Millisecond), status.Uptime, checked, status.displayName())
+	}
+	table.Flush()
+}
+
+// logCheckResult 記錄一次檢查的結果，JSON 模式下輸出結構化欄位
+func logCheckResult(url string, result CheckResult, err error) {
+	if jsonLogging {
+		attrs := []any{
+			"url", url,
+			"method", result.Method,
+			"status", result.Status,
+			"status_message", result.StatusMessage,
+			"response_time_ms", result.ResponseTime.Milliseconds(),
+		}
+		if err != nil {
+			slog.Error("check failed", append(attrs, "error", err.Error())...)
+			return
+		}
+		slog.Info("check completed", attrs...)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error checking %s after %v: %v", url, result.ResponseTime, err)
+		return
+	}
+	log.Printf("Checked %s (%s) - Status: %s, Response time: %v", url, result.Method, result.StatusMessage, result.ResponseTime)
+}
+
+// 連線失敗的原因分類，記錄在 ErrorKind
+const (
+	errorDNS        = "dns"        // 無法解析主機名稱，通常是網址打錯
+	errorRefused    = "refused"    // 連線被拒絕，主機存在但端口沒有服務
+	errorTimeout    = "timeout"    // 連線或等待回應逾時
+	errorTLS        = "tls"        // TLS 交握或憑證驗證失敗
+	errorConnection = "connection" // 其他連線錯誤
+)
+
+// classifyConnError 依底層錯誤分類連線失敗的原因，返回分類與顯示用的狀態說明
+func classifyConnError(err error) (kind, message string) {
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		if dnsErr.IsNotFound {
+			return errorDNS, "DNS Error: No Such Host"
+		}
+		return errorDNS, "DNS Error"
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return errorTLS, "TLS Error"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return errorTimeout, "Timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return errorRefused, "Connection Refused"
+	}
+	return errorConnection, "Connection Error"
+}
+
+// shouldRetry 判斷檢查結果是否屬於值得重試的失敗 (連線錯誤或 5xx)；
+// expected_status 或 health_rules 接受的 5xx 是正常的回應，不重試
+func shouldRetry(result CheckResult) bool {
+	return !result.Healthy() && (result.Status == 0 || result.Status >= 500)
+}
+
+// performCheck 對網址送出一次請求並返回結果，連線失敗時一併返回錯誤；
+// ctx 被取消時立即中斷進行中的請求，返回的錯誤包含 ctx.Err()
+func performCheck(ctx context.Context, target URLConfig) (CheckResult, error) {
+	if target.sourceIP != nil {
+		ctx = context.WithValue(ctx, sourceIPKey{}, target.sourceIP)
+	}
+	switch checkKind(target.URL) {
+	case kindTCP:
+		return performTCPCheck(ctx, target)
+	case kindPing:
+		return performPingCheck(ctx, target)
+	}
+
+	url := target.URL
+	method := target.Method
+	start := time.Now()
+	trace := &checkTrace{}
+
+	resp, err := sendRequest(ctx, target, method, trace)
+	if err == nil && method == http.MethodHead && resp.StatusCode == http.StatusMethodNotAllowed {
+		// 伺服器不接受 HEAD 時，自動改用 GET 重新檢查
+		resp.Body.Close()
+		log.Printf("%s does not allow HEAD, falling back to GET", url)
+		method = http.MethodGet
+		start = time.Now()
+		trace = &checkTrace{}
+		resp, err = sendRequest(ctx, target, method, trace)
+	}
+	if err != nil {
+		// 逾時或連線失敗時，記錄到發生錯誤為止所經過的時間與失敗原因
+		errorKind, message := classifyConnError(err)
+		return CheckResult{
+			Status:        0,
+			StatusMessage: message,
+			ErrorKind:     errorKind,
+			CheckedTime:   start,
+			ResponseTime:  time.Since(start),
+			Method:        method,
+			Kind:          kindHTTP,
+			RemoteIP:      trace.remoteIP(),
+		}, err
+	}
+	defer drainAndClose(resp.Body)
+
+	result := CheckResult{
+		Status:        resp.StatusCode,
+		StatusMessage: statusText(resp.StatusCode),
+		CheckedTime:   start,
+		TTFB:          trace.ttfb(start),
+		Method:        method,
+		Kind:          kindHTTP,
+		CertExpiry:    certExpiry(resp),
+		ContentLength: resp.ContentLength,
+		ContentType:   resp.Header.Get("Content-Type"),
+		Headers:       captureHeaders(resp.Header, target.CaptureHeaders),
+		FinalURL:      resp.Request.URL.String(),
+		RedirectHops:  redirectHops(resp),
+		Proto:         resp.Proto,
+		ConnReused:    trace.connReused(),
+		RemoteIP:      trace.remoteIP(),
+	}
+	result.DNSTime, result.ConnectTime, result.TLSTime = trace.phases()
+
+	// 讀取回應內容（解壓縮後最多保留 body_limit 位元組，避免過大或串流的回應耗盡記憶體；
+	// 設定了更大的 max_body_size 時超過的部分只計算大小不保留），
+	// ResponseTime 包含傳輸內容的時間，TTFB 則只到收到第一個位元組
+	wire := &countingReader{r: resp.Body}
+	decoded, err := decodeBody(resp.Header.Get("Content-Encoding"), wire)
+	var body []byte
+	if err == nil {
+		body, result.BodySize, result.Truncated, err = readBody(decoded, target.BodyLimit, target.sizeCountLimit())
+	}
+	result.ResponseTime = time.Since(start)
+	result.ContentEncoding = resp.Header.Get("Content-Encoding")
+	result.WireSize = wire.n
+	if err != nil {
+		return result, fmt.Errorf("reading body: %w", err)
+	}
+	if target.DetectContentChange && method != http.MethodHead {
+		sum := sha256.Sum256(body)
+		result.ContentHash = hex.EncodeToString(sum[:])
+	}
+	// 內容被截斷時無法確認超過上限的部分是否符合，在訊息中註明
+	truncated := ""
+	if result.Truncated {
+		truncated = fmt.Sprintf(" (Body Truncated at %d Bytes)", target.BodyLimit)
+	}
+
+	if len(target.HealthRules) > 0 {
+		rule, ok := target.matchHealthRule(resp, body)
+		switch {
+		case !ok:
+			result.StatusMessage = "No Health Rule Matched" + truncated
+			result.CheckFailed = true
+		case target.hasSizeCheck() && !target.sizeMatches(result.BodySize):
+			result.StatusMessage = "Size Mismatch"
+			result.CheckFailed = true
+		default:
+			result.MatchedRule = rule
+			result.Expected = !isUp(resp.StatusCode)
+		}
+		return result, nil
+	}
+	if !target.expectsStatus(resp.StatusCode) {
+		if len(target.ExpectedStatus) > 0 {
+			result.StatusMessage = "Unexpected Status"
+			result.CheckFailed = true
+		}
+		return result, nil
+	}
+	result.Expected = !isUp(resp.StatusCode)
+	if target.hasSizeCheck() && !target.sizeMatches(result.BodySize) {
+		result.StatusMessage = "Size Mismatch"
+		result.CheckFailed = true
+	} else if target.hasContentCheck() && !contentMatches(target, body) {
+		result.StatusMessage = "Content Mismatch" + truncated
+		result.CheckFailed = true
+	} else if !headersMatch(resp.Header, target.ExpectHeaders) {
+		result.StatusMessage = "Header Mismatch"
+		result.CheckFailed = true
+	}
+	return result, nil
+}
+
+// readBody 讀取回應內容，最多保留 limit 位元組；超過 limit 時標示為截斷，
+// 並繼續讀取 (不保留) 到 countLimit 位元組為止，返回的 size 為實際讀取的位元組數
+func readBody(r io.Reader, limit, countLimit int64) (body []byte, size int64, truncated bool, err error) {
+	body, err = io.ReadAll(io.LimitReader(r, limit+1))
+	size = int64(len(body))
+	if err != nil || size <= limit {
+		return body, size, false, err
+	}
+	body = body[:limit]
+	if countLimit > size {
+		var n int64
+		n, err = io.Copy(io.Discard, io.LimitReader(r, countLimit-size))
+		size += n
+	}
+	return body, size, true, err
+}
+
+// drainAndClose 讀完少量剩餘的回應內容後關閉，讓連線可以重複使用；
+// 剩餘超過 maxDrainBytes 時直接關閉連線，避免為了重複使用連線而下載整個大檔案
+func drainAndClose(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, maxDrainBytes))
+	body.Close()
+}
+
+// countingReader 計算實際從連線讀取的位元組數 (壓縮後的大小)
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// decodeBody 依 Content-Encoding 解壓縮回應內容，讓內容檢查比對的是解壓縮後的文字；
+// deflate 依規範應為 zlib 格式，但也接受部分伺服器送出的原始 deflate，其他編碼原樣返回
+func decodeBody(encoding string, r io.Reader) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		buffered := bufio.NewReader(r)
+		header, err := buffered.Peek(2)
+		if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(buffered)
+		}
+		return flate.NewReader(buffered), nil
+	}
+	return r, nil
+}
+
+// performTCPCheck 嘗試建立 TCP 連線，記錄是否成功與連線所需時間
+// 連線成功時記錄為 tcpConnectedStatus，沿用 HTTP 的正常判斷
+func performTCPCheck(ctx context.Context, target URLConfig) (CheckResult, error) {
+	u, err := neturl.Parse(target.URL)
+	if err != nil {
+		return CheckResult{StatusMessage: "Connection Error", CheckedTime: time.Now(), Kind: kindTCP}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, httpClient.Timeout)
+	defer cancel()
+	if target.IPVersion != "" {
+		ctx = context.WithValue(ctx, ipVersionKey{}, target.IPVersion)
+	}
+
+	start := time.Now()
+	conn, err := dialContext(ctx, "tcp", u.Host)
+	duration := time.Since(start)
+	if err != nil {
+		errorKind, message := classifyConnError(err)
+		return CheckResult{
+			Status:        0,
+			StatusMessage: message,
+			ErrorKind:     errorKind,
+			CheckedTime:   start,
+			ResponseTime:  duration,
+			Kind:          kindTCP,
+		}, err
+	}
+	defer conn.Close()
+
+	remoteIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	return CheckResult{
+		Status:        tcpConnectedStatus,
+		StatusMessage: "Connected",
+		CheckedTime:   start,
+		ResponseTime:  duration,
+		ConnectTime:   duration, // TCP 檢查只有建立連線 (包含 DNS 查詢)，整段都算連線時間
+		Kind:          kindTCP,
+		RemoteIP:      remoteIP,
+	}, nil
+}
+
+// pingFallbackOnce 只在第一次改用 TCP ping 時記錄原因
+var pingFallbackOnce sync.Once
+
+// pingPayload echo 請求附帶的資料
+var pingPayload = []byte("website-detection")
+
+// echoFunc 送出一次 echo 並等待回覆，返回往返時間；ctx 的期限為這次等待的上限
+type echoFunc func(ctx context.Context, seq int) (time.Duration, error)
+
+// performPingCheck 送出 ping_count 個 ICMP echo 請求，ResponseTime 為收到回覆的平均往返時間，
+// PacketLoss 為未收到回覆的比例，至少收到一個回覆時記錄為 tcpConnectedStatus；
+// 沒有開啟 raw socket 的權限時改以 TCP 連線到網址的端口 (預設 80) 代替
+func performPingCheck(ctx context.Context, target URLConfig) (CheckResult, error) {
+	start := time.Now()
+	u, err := neturl.Parse(target.URL)
+	if err != nil {
+		return CheckResult{StatusMessage: "Connection Error", CheckedTime: start, Kind: kindPing}, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, httpClient.Timeout)
+	defer cancel()
+
+	network := "ip"
+	switch target.IPVersion {
+	case "4":
+		network = "ip4"
+	case "6":
+		network = "ip6"
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, network, u.Hostname())
+	if err == nil && len(addrs) == 0 {
+		err = &net.DNSError{Err: "no suitable address", Name: u.Hostname(), IsNotFound: true}
+	}
+	if err != nil {
+		errorKind, message := classifyConnError(err)
+		return CheckResult{StatusMessage: message, ErrorKind: errorKind, CheckedTime: start, ResponseTime: time.Since(start), Kind: kindPing}, err
+	}
+	ip := addrs[0].Unmap()
+	result := CheckResult{CheckedTime: start, Kind: kindPing, RemoteIP: ip.String(), DNSTime: time.Since(start)}
+
+	echo, conn, err := icmpEcho(ip, target.sourceIP)
+	via := ""
+	switch {
+	case errors.Is(err, os.ErrPermission):
+		pingFallbackOnce.Do(func() {
+			log.Printf("ICMP ping is not permitted (run as root or grant CAP_NET_RAW, e.g. setcap cap_net_raw+ep), falling back to TCP ping: %v", err)
+		})
+		port := u.Port()
+		if port == "" {
+			port = defaultPingPort
+		}
+		echo, via = tcpEcho(net.JoinHostPort(ip.String(), port)), " via TCP"
+	case err != nil:
+		errorKind, message := classifyConnError(err)
+		result.StatusMessage, result.ErrorKind, result.ResponseTime = message, errorKind, time.Since(start)
+		return result, err
+	default:
+		defer conn.Close()
+	}
+
+	// 每個請求平分剩下的逾時，讓遺失的封包不會讓整次檢查超過 timeout
+	count := max(target.PingCount, 1)
+	var total time.Duration
+	received := 0
+	var lastErr error
+	for seq := 1; seq <= count; seq++ {
+		remaining := time.Until(start.Add(httpClient.Timeout))
+		probeCtx, cancelProbe := context.WithTimeout(ctx, remaining/time.Duration(count-seq+1))
+		rtt, err := echo(probeCtx, seq)
+		cancelProbe()
+		if err != nil {
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		total += rtt
+		received++
+	}
+	result.PacketLoss = float64(count-received) / float64(count)
+	if received == 0 {
+		errorKind, message := classifyConnError(lastErr)
+		result.StatusMessage, result.ErrorKind, result.ResponseTime = message+via, errorKind, time.Since(start)
+		return result, lastErr
+	}
+	result.Status = tcpConnectedStatus
+	result.ResponseTime = total / time.Duration(received)
+	result.StatusMessage = "Reply" + via
+	if received < count {
+		result.StatusMessage += fmt.Sprintf(" (%.0f%% Loss)", result.PacketLoss*100)
+	}
+	return result, nil
+}
+
+// icmpEcho 開啟 ICMP raw socket (source 不為 nil 時綁定該來源 IP) 並返回對 ip 送出 echo 請求的函式，
+// 呼叫者負責關閉返回的連線；沒有權限時返回的錯誤符合 os.ErrPermission
+func icmpEcho(ip netip.Addr, source net.IP) (echoFunc, net.PacketConn, error) {
+	network, request, reply := "ip4:icmp", byte(8), byte(0)
+	if ip.Is6() {
+		network, request, reply = "ip6:ipv6-icmp", 128, 129
+	}
+	local := ""
+	if source != nil {
+		local = source.String()
+	}
+	conn, err := net.ListenPacket(network, local)
+	if err != nil {
+		return nil, nil, err
+	}
+	// raw socket 會收到所有的 ICMP 封包，以隨機的 identifier 區分同時進行的檢查
+	id := uint16(rand.Uint32())
+	dst := &net.IPAddr{IP: ip.AsSlice(), Zone: ip.Zone()}
+	echo := func(ctx context.Context, seq int) (time.Duration, error) {
+		msg := make([]byte, 8+len(pingPayload))
+		msg[0] = request
+		binary.BigEndian.PutUint16(msg[4:], id)
+		binary.BigEndian.PutUint16(msg[6:], uint16(seq))
+		copy(msg[8:], pingPayload)
+		if ip.Is4() {
+			// ICMPv6 的 checksum 由核心計算
+			binary.BigEndian.PutUint16(msg[2:], icmpChecksum(msg))
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		}
+		begin := time.Now()
+		if _, err := conn.WriteTo(msg, dst); err != nil {
+			return 0, err
+		}
+		buf := make([]byte, 1500)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return 0, err
+			}
+			if n >= 8 && buf[0] == reply && binary.BigEndian.Uint16(buf[4:]) == id && binary.BigEndian.Uint16(buf[6:]) == uint16(seq) {
+				return time.Since(begin), nil
+			}
+		}
+	}
+	return echo, conn, nil
+}
+
+// icmpChecksum 計算 ICMP 訊息的 checksum (RFC 1071)
+func icmpChecksum(msg []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(msg); i += 2 {
+		sum += uint32(msg[i])<<8 | uint32(msg[i+1])
+	}
+	if len(msg)%2 == 1 {
+		sum += uint32(msg[len(msg)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// tcpEcho 返回以 TCP 連線代替 echo 的函式；連線被拒絕代表主機有回應，同樣算收到回覆
+func tcpEcho(addr string) echoFunc {
+	return func(ctx context.Context, seq int) (time.Duration, error) {
+		begin := time.Now()
+		conn, err := dialContext(ctx, "tcp", addr)
+		rtt := time.Since(begin)
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return rtt, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		conn.Close()
+		return rtt, nil
+	}
+}
+
+// contentMatches 檢查回應內容是否符合網址設定的字串與正規表示式
+func contentMatches(target URLConfig, body []byte) bool {
+	if target.Content != "" && !bytes.Contains(body, []byte(target.Content)) {
+		return false
+	}
+	if target.contentPattern != nil && !target.contentPattern.Match(body) {
+		return false
+	}
+	return true
+}
+
+// certExpiry 取得 https 回應中葉憑證的到期時間，http 回應返回零值
+func certExpiry(resp *http.Response) time.Time {
+	if resp.TLS == nil {
+		return time.Time{}
+	}
+	if len(resp.TLS.VerifiedChains) > 0 && len(resp.TLS.VerifiedChains[0]) > 0 {
+		return resp.TLS.VerifiedChains[0][0].NotAfter
+	}
+	if len(resp.TLS.PeerCertificates) > 0 {
+		return resp.TLS.PeerCertificates[0].NotAfter
+	}
+	return time.Time{}
+}
+
+// checkTrace 透過 httptrace 收集單次請求的連線資訊
+type checkTrace struct {
+	mu         sync.Mutex
+	remoteAddr string    // 實際連線（或最後嘗試連線）的位址
+	firstByte  time.Time // 收到回應第一個位元組的時間
+	reused     bool      // 最後一次取得的連線是否為重複使用的閒置連線
+
+	// 各階段的開始與結束時間，重新導向時記錄最後一次建立的連線
+	dnsStart, dnsDone         time.Time
+	connectStart, connectDone time.Time
+	tlsStart, tlsDone         time.Time
+}
+
+// clientTrace 返回會更新 checkTrace 的 httptrace.ClientTrace
+func (t *checkTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart, t.dnsDone = time.Now(), time.Time{}
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.dnsDone = time.Now()
+			t.mu.Unlock()
+		},
+		ConnectStart: func(network, addr string) {
+			t.mu.Lock()
+			t.remoteAddr = addr
+			// 同時嘗試多個位址 (Happy Eyeballs) 時，以第一次嘗試作為開始時間
+			if t.connectStart.IsZero() || !t.connectDone.IsZero() {
+				t.connectStart, t.connectDone = time.Now(), time.Time{}
+			}
+			t.mu.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			if err != nil {
+				return
+			}
+			t.mu.Lock()
+			t.connectDone = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.tlsStart, t.tlsDone = time.Now(), time.Time{}
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			t.tlsDone = time.Now()
+			t.mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.remoteAddr = info.Conn.RemoteAddr().String()
+			t.reused = info.Reused
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.firstByte = time.Now()
+			t.mu.Unlock()
+		},
+	}
+}
+
+// ttfb 返回從 start 到收到第一個位元組的時間，尚未收到時返回 0
+func (t *checkTrace) ttfb(start time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.firstByte.IsZero() {
+		return 0
+	}
+	return t.firstByte.Sub(start)
+}
+
+// phases 返回 DNS 查詢、TCP 連線與 TLS 交握各花費的時間，沒有經過的階段為 0
+// (例如重複使用連線、直接以 IP 連線或非 TLS 的網址)
+func (t *checkTrace) phases() (dns, connect, tlsHandshake time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	elapsed := func(start, done time.Time) time.Duration {
+		if start.IsZero() || done.IsZero() {
+			return 0
+		}
+		return done.Sub(start)
+	}
+	return elapsed(t.dnsStart, t.dnsDone), elapsed(t.connectStart, t.connectDone), elapsed(t.tlsStart, t.tlsDone)
+}
+
+// connReused 返回最後一次取得的連線是否為重複使用的連線
+func (t *checkTrace) connReused() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.reused
+}
+
+// remoteIP 返回連線位址中的 IP 部分
+func (t *checkTrace) remoteIP() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	host, _, err := net.SplitHostPort(t.remoteAddr)
+	if err != nil {
+		return t.remoteAddr
+	}
+	return host
+}
+
+// ipVersionKey 在請求的 context 中存放連線時限定的 IP 版本
+type ipVersionKey struct{}
+
+// checkDialer 檢查網站時使用的 Dialer
+var checkDialer = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+// sourceIPKey 在請求的 context 中保存探測點的本機來源 IP
+type sourceIPKey struct{}
+
+// dialContext 依請求 context 中的 IP 版本偏好選擇 tcp4 或 tcp6，並綁定探測點的來源 IP
+func dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch ctx.Value(ipVersionKey{}) {
+	case "4":
+		network = "tcp4"
+	case "6":
+		network = "tcp6"
+	}
+	if source, ok := ctx.Value(sourceIPKey{}).(net.IP); ok {
+		dialer := *checkDialer
+		dialer.LocalAddr = &net.TCPAddr{IP: source}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return checkDialer.DialContext(ctx, network, addr)
+}
+
+// newCheckTransport 建立檢查網站用的 Transport；綁定來源 IP 或限定 ip_version 的請求各自使用一個 Transport，
+// 連線池以主機為鍵，共用時會重複使用以其他來源 IP 或位址類型建立的連線
+func newCheckTransport() http.RoundTripper {
+	return &dialRoutingTransport{direct: newTLSRoutingTransport(), byDial: make(map[string]http.RoundTripper)}
+}
+
+// dialRoutingTransport 依請求 context 中的 sourceIPKey 與 ipVersionKey 選擇連線方式專用的 Transport
+type dialRoutingTransport struct {
+	direct http.RoundTripper
+	mu     sync.Mutex
+	byDial map[string]http.RoundTripper
+}
+
+func (t *dialRoutingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	source, _ := req.Context().Value(sourceIPKey{}).(net.IP)
+	version, _ := req.Context().Value(ipVersionKey{}).(string)
+	if source == nil && version == "" {
+		return t.direct.RoundTrip(req)
+	}
+	key := version + "/" + source.String()
+	t.mu.Lock()
+	transport, ok := t.byDial[key]
+	if !ok {
+		transport = newTLSRoutingTransport()
+		t.byDial[key] = transport
+	}
+	t.mu.Unlock()
+	return transport.RoundTrip(req)
+}
+
+// newTLSRoutingTransport 建立以 dialContext 控制位址類型的 Transport；
+// 設定 insecure_skip_verify 的網址改用另一個不驗證憑證的 Transport，連線不會與一般網址共用
+func newTLSRoutingTransport() http.RoundTripper {
+	secure := http.DefaultTransport.(*http.Transport).Clone()
+	secure.DialContext = dialContext
+	secure.Proxy = proxyForRequest
+	insecure := secure.Clone()
+	insecure.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	return tlsRoutingTransport{secure: secure, insecure: insecure}
+}
+
+// insecureKey 在請求的 context 中標記不驗證 TLS 憑證
+type insecureKey struct{}
+
+// tlsRoutingTransport 依請求 context 中的 insecureKey 選擇是否驗證 TLS 憑證
+type tlsRoutingTransport struct {
+	secure, insecure *http.Transport
+}
+
+func (t tlsRoutingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if skip, _ := req.Context().Value(insecureKey{}).(bool); skip {
+		return t.insecure.RoundTrip(req)
+	}
+	return t.secure.RoundTrip(req)
+}
+
+// proxyKey 在請求的 context 中保存網址自身的代理設定
+type proxyKey struct{}
+
+// proxyForRequest 網址設定了 proxy 時直接使用 (nil 代表 direct)，
+// 否則依 HTTP_PROXY、HTTPS_PROXY 與 NO_PROXY 環境變數決定
+func proxyForRequest(req *http.Request) (*neturl.URL, error) {
+	if proxyURL, ok := req.Context().Value(proxyKey{}).(*neturl.URL); ok {
+		return proxyURL, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// applyGlobalProxy 將設定檔的全域 proxy 寫入 HTTP_PROXY 與 HTTPS_PROXY，
+// 讓它和環境變數一樣遵守 NO_PROXY；需在第一次送出請求前呼叫
+func applyGlobalProxy(proxy string) {
+	if proxy == "" {
+		return
+	}
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY"} {
+		os.Setenv(name, proxy)
+	}
+	log.Printf("Using proxy %s for HTTP checks", redactURL(proxy))
+}
+
+// redactURL 遮蔽網址中的密碼，供寫入日誌使用
+func redactURL(rawURL string) string {
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
+
+// sendRequest 以指定的方法對網址送出請求，並套用該網址的重新導向與 IP 版本設定；
+// 請求綁定 ctx，關閉時取消 ctx 會中斷連線與傳輸中的回應內容
+func sendRequest(ctx context.Context, target URLConfig, method string, trace *checkTrace) (*http.Response, error) {
+	var body io.Reader
+	if target.Body != "" {
+		body = strings.NewReader(target.Body)
+	}
+	ctx = context.WithValue(ctx, followRedirectsKey{}, target.followRedirects())
+	if target.IPVersion != "" {
+		ctx = context.WithValue(ctx, ipVersionKey{}, target.IPVersion)
+	}
+	if target.Proxy != "" {
+		ctx = context.WithValue(ctx, proxyKey{}, target.proxyURL)
+	}
+	if target.InsecureSkipVerify {
+		ctx = context.WithValue(ctx, insecureKey{}, true)
+	}
+	ctx = httptrace.WithClientTrace(ctx, trace.clientTrace())
+	req, err := http.NewRequestWithContext(ctx, method, target.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", target.UserAgent)
+	// 自行要求並解壓縮內容，才能同時記錄傳輸大小與解壓縮後的大小；
+	// 自訂的 Accept-Encoding 標頭會取代這個值，回應同樣由 decodeBody 處理
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	if target.Body != "" {
+		req.Header.Set("Content-Type", target.ContentType)
+	}
+	for name, value := range target.Headers {
+		if strings.EqualFold(name, "Host") {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	if target.BasicAuth != nil {
+		req.SetBasicAuth(target.BasicAuth.Username, target.BasicAuth.Password)
+	} else if target.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+target.BearerToken)
+	}
+	debugf("Requesting %s %s with headers %v and a %d-byte body", method, target.URL, maskHeaders(req.Header), len(target.Body))
+	return httpClient.Do(req)
+}
+
+// maskHeaders 返回標頭的副本，並將可能含有密鑰的值遮蔽，供寫入日誌使用
+func maskHeaders(header http.Header) http.Header {
+	masked := make(http.Header, len(header))
+	for name, values := range header {
+		if isSecretHeader(name) {
+			masked[name] = []string{"***"}
+			continue
+		}
+		masked[name] = values
+	}
+	return masked
+}
+
+// isSecretHeader 判斷標頭名稱是否可能含有密鑰或憑證
+func isSecretHeader(name string) bool {
+	lower := strings.ToLower(name)
+	for _, keyword := range []string{"authorization", "cookie", "token", "secret", "key", "password"} {
+		if strings.Contains(lower, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// followRedirectsKey 在請求的 context 中存放是否跟隨重新導向
+type followRedirectsKey struct{}
+
+// checkRedirect 依請求 context 中的設定決定是否跟隨重新導向
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if follow, ok := req.Context().Value(followRedirectsKey{}).(bool); ok && !follow {
+		return http.ErrUseLastResponse
+	}
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	return nil
+}
+
+// redirectHops 由最終回應往回追溯，計算經過的重新導向次數
+func redirectHops(resp *http.Response) int {
+	hops := 0
+	for r := resp.Request.Response; r != nil; r = r.Request.Response {
+		hops++
+	}
+	return hops
+}
+
+// CheckResult 單次檢查的結果
+type CheckResult struct {
+	Status            int
+	StatusMessage     string
+	CheckedTime       time.Time
+	ResponseTime      time.Duration     // 包含讀取回應內容的總時間
+	TTFB              time.Duration     // 收到第一個回應位元組的時間
+	Method            string            // 實際使用的 HTTP 方法
+	Kind              string            // 檢查方式：http、tcp 或 ping
+	PacketLoss        float64           // ping 檢查未收到回覆的比例，0 到 1
+	CertExpiry        time.Time         // https 憑證的到期時間，http 網址為零值
+	CheckFailed       bool              // 未通過內容檢查或狀態碼不符預期
+	Expected          bool              // 狀態碼不在 2xx 但符合網址設定的 expected_status
+	DegradedThreshold time.Duration     // 該網址的延遲門檻，由 updateStatus 與移動平均比較
+	AlertCooldown     time.Duration     // 該網址同一種通知的最短間隔，來自設定
+	Maintenance       bool              // 檢查時網址處於維護時段
+	Group             string            // 網址所屬的群組，來自設定
+	Tags              []string          // 網址的標籤，來自設定
+	Name              string            // 網址的顯示名稱，來自設定
+	Critical          bool              // 網址設定為重要，來自設定
+	MatchedRule       string            // 符合的 health_rules 規則名稱，未設定規則或都不符合時為空字串
+	AlertOnIPChange   bool              // 網址設定了 alert_on_ip_change
+	ErrorKind         string            // 連線失敗的原因：dns、refused、timeout、tls 或 connection
+	ContentLength     int64             // 回應的 Content-Length，-1 代表未知
+	ContentType       string            // 回應的 Content-Type
+	ContentEncoding   string            // 回應的 Content-Encoding，例如 gzip
+	WireSize          int64             // 實際傳輸的回應內容位元組數 (壓縮後)
+	BodySize          int64             // 解壓縮後讀取的回應內容位元組數，最多讀到 body_limit 或檢查大小範圍所需的位元組數
+	Truncated         bool              // 回應內容超過 body_limit，內容檢查只使用前 body_limit 位元組
+	ContentHash       string            // 設定 detect_content_change 時回應內容的 SHA-256 (hex)
+	Probes            []ProbeResult     // 設定 probes 時各探測點的結果
+	ProbesFailed      int               // 失敗的探測點數量
+	Headers           map[string]string // 依 capture_headers 記錄的回應標頭
+	RemoteIP          string            // 實際連線（或最後嘗試連線）的 IP
+	DNSTime           time.Duration     // DNS 查詢時間
+	ConnectTime       time.Duration     // TCP 連線時間
+	TLSTime           time.Duration     // TLS 交握時間，非 TLS 的網址為 0
+	Proto             string            // 回應使用的通訊協定，例如 HTTP/1.1 或 HTTP/2.0
+	ConnReused        bool              // 是否重複使用了先前的連線
+	FinalURL          string            // 跟隨重新導向後最終的網址
+	RedirectHops      int               // 經過的重新導向次數
+}
+
+// Healthy 判斷這次檢查是否代表網站正常
+func (r CheckResult) Healthy() bool {
+	return (isUp(r.Status) || r.Expected) && !r.CheckFailed
+}
+
+// 更新網站狀態，返回新增的歷史紀錄
+func updateStatus(url string, result CheckResult) HistoryStatus {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	// 檢查是否已經存在於狀態記錄中，如果不存在，則初始化
+	current, exists := currentStatus[url]
+	if !exists {
+		current = WebsiteStatus{URL: url}
+	}
+
+	entry := HistoryStatus{
+		Status:        result.Status,
+		StatusMessage: result.StatusMessage,
+		CheckedTime:   result.CheckedTime,
+		ResponseTime:  result.ResponseTime,
+		TTFB:          result.TTFB,
+		CheckFailed:   result.CheckFailed,
+		Expected:      result.Expected,
+		ErrorKind:     result.ErrorKind,
+		ContentLength: result.ContentLength,
+		ContentType:   result.ContentType,
+	}
+	healthy := entry.Healthy()
+	previous := current
+
+	// 更新目前狀態，並將新狀態添加到歷史記錄中
+	current.Status = result.Status
+	current.StatusMessage = result.StatusMessage
+	current.LastChecked = result.CheckedTime
+	current.ResponseTime = result.ResponseTime
+	current.TTFB = result.TTFB
+	current.Method = result.Method
+	current.Kind = result.Kind
+	current.CheckFailed = result.CheckFailed
+	current.Expected = result.Expected
+	current.ErrorKind = result.ErrorKind
+	if healthy {
+		current.LastSeenUp = result.CheckedTime
+		current.DownSince = time.Time{}
+	} else if current.DownSince.IsZero() {
+		current.DownSince = result.CheckedTime
+	}
+	// 連線錯誤沒有有意義的回應時間，不列入移動平均
+	if result.Status != 0 {
+		current.LatencyEMA = nextEMA(current.LatencyEMA, result.ResponseTime, latencyEMAAlpha)
+	}
+	// 部分探測點失敗時網站仍算正常，但與回應過慢一樣標示為 degraded
+	slow := (result.DegradedThreshold > 0 && current.LatencyEMA > result.DegradedThreshold) || result.ProbesFailed > 0
+	current.State = classifyState(result.Status, result.Expected, result.CheckFailed, slow)
+	current.Maintenance = result.Maintenance
+	current.Group = result.Group
+	current.Name = result.Name
+	current.Critical = result.Critical
+	current.Tags = result.Tags
+	current.MatchedRule = result.MatchedRule
+	if result.Maintenance {
+		current.State = stateMaintenance
+	}
+	current.ContentLength = result.ContentLength
+	current.ContentType = result.ContentType
+	current.ContentEncoding = result.ContentEncoding
+	current.WireSize = result.WireSize
+	current.BodySize = result.BodySize
+	current.Truncated = result.Truncated
+	current.PacketLoss = result.PacketLoss
+	current.Probes = result.Probes
+	current.Headers = result.Headers
+	current.RemoteIP = result.RemoteIP
+	current.DNSTime = result.DNSTime
+	current.ConnectTime = result.ConnectTime
+	current.TLSTime = result.TLSTime
+	// 各探測點連線到的 IP 不同 (例如經由不同的代理伺服器)，合併後的 RemoteIP 會隨回應的探測點改變，
+	// 設定 probes 時不偵測 IP 變更
+	newIP := false
+	if len(result.Probes) == 0 {
+		newIP = trackIP(&current, result.RemoteIP)
+	} else {
+		current.IPChanged, current.PreviousIP = false, ""
+	}
+	// 只和前一次正常檢查的內容比較，異常時的錯誤頁面不會取代記錄的雜湊值
+	current.ContentChanged = false
+	if healthy && result.ContentHash != "" {
+		if current.ContentHash != "" && current.ContentHash != result.ContentHash {
+			current.ContentChanged = true
+			current.ContentChangedAt = result.CheckedTime
+			entry.ChangedContent = true
+		}
+		current.ContentHash = result.ContentHash
+	}
+	current.Proto = result.Proto
+	current.ConnReused = result.ConnReused
+	current.FinalURL = result.FinalURL
+	current.RedirectHops = result.RedirectHops
+	if !result.CertExpiry.IsZero() {
+		current.CertExpiry = result.CertExpiry
+	}
+	if !current.CertExpiry.IsZero() {
+		remaining := current.CertExpiry.Sub(result.CheckedTime)
+		current.DaysUntilExpiry = int(remaining.Hours() / 24)
+		current.CertExpiringSoon = remaining < certExpiryWarning
+	}
+	current.HistoryStatuses = append(current.HistoryStatuses, entry)
+	current.HistoryStatuses = trimHistory(current.HistoryStatuses, maxHistory)
+	current.Uptime = uptimePercentage(current.HistoryStatuses, uptimeWindow)
+	current.UptimeWindows = windowUptimes(current.HistoryStatuses, result.CheckedTime)
+	current.AvgResponseTime, current.MinResponseTime, current.MaxResponseTime = responseTimeStats(current.HistoryStatuses, statsWindow)
+	current.Histogram = responseTimeHistogram(current.HistoryStatuses, statsWindow, histogramBuckets)
+	current.Timeline = statusTimeline(current.HistoryStatuses, timelineBucket)
+	current.Flapping = isFlapping(current.HistoryStatuses)
+	currentStatus[url] = current
+	events.publish(current)
+
+	// 網站在正常與異常之間轉換時發送通知，頻繁切換或維護期間不逐次通知；
+	// 維護結束時若網站仍異常，則補發一次異常通知
+	// 尚未完成第一次檢查 (pending) 的網址沒有可比較的狀態，不發送通知
+	changed := exists && previous.State != statePending && previous.Healthy() != healthy
+	if previous.Maintenance && !current.Maintenance {
+		changed = !healthy
+	}
+	if changed {
+		if current.Maintenance {
+			log.Printf("%s is in maintenance, suppressing alert", url)
+		} else if current.Flapping {
+			log.Printf("%s is flapping, suppressing alert", url)
+		} else {
+			alert := Alert{
+				URL:       url,
+				OldStatus: previous.Status,
+				NewStatus: result.Status,
+				Message:   result.StatusMessage,
+				Time:      result.CheckedTime,
+				Up:        healthy,
+			}
+			if throttleTransition(alert, result.AlertCooldown) {
+				dispatchAlert(alert)
+			}
+		}
+	} else if !current.Maintenance && !current.Flapping {
+		flushDeferredAlert(url, healthy, result)
+	}
+	// 每次異常排定一次升級通知，與即時通知是否因第一次檢查、頻繁切換或冷卻時間而略過無關；
+	// 維護期間不排定，維護結束時網站仍異常則重新計時
+	if healthy {
+		cancelEscalation(url)
+	} else if !current.Maintenance {
+		if _, scheduled := escalations[url]; !scheduled || previous.Maintenance {
+			scheduleEscalation(url, current.DownSince)
+		}
+	}
+	if current.ContentChanged {
+		log.Printf("%s content changed (sha256 %s)", url, current.ContentHash)
+	}
+	if current.IPChanged {
+		log.Printf("%s IP changed from %s to %s", url, current.PreviousIP, current.RemoteIP)
+		if newIP && result.AlertOnIPChange && !current.Maintenance && cooldownAllows(url, alertKindIPChange, result.CheckedTime, result.AlertCooldown) {
+			dispatchAlert(Alert{
+				URL:        url,
+				OldStatus:  previous.Status,
+				NewStatus:  result.Status,
+				Message:    result.StatusMessage,
+				Time:       result.CheckedTime,
+				Up:         healthy,
+				IPChange:   true,
+				PreviousIP: current.PreviousIP,
+				RemoteIP:   current.RemoteIP,
+			})
+		}
+	}
+
+	// 同步更新 Prometheus 指標，讓 /metrics 與 currentStatus 一致
+	m, ok := metrics[url]
+	if !ok {
+		m = &urlMetrics{}
+		metrics[url] = m
+	}
+	m.LastStatus = result.Status
+	m.Up = healthy
+	m.ResponseSeconds = result.ResponseTime.Seconds()
+	m.Checks++
+
+	lastCheckTime = time.Now()
+
+	// 標記有變更，由 flushHistoryPeriodically 定期寫入檔案
+	historyDirty = true
+	return entry
+}
+
+// maxKnownIPs 每個網址記住的最近連線 IP 數量，足以涵蓋一般的多筆 A 記錄輪替
+const maxKnownIPs = 8
+
+// trackIP 將這次連線的 IP 記錄到 KnownIPs，並依前一次的 IP 設定 IPChanged；
+// 返回是否為最近沒見過的新 IP。連線失敗沒有 IP 時保留原本的紀錄，
+// 第一次連線沒有可比較的 IP，不視為變更
+func trackIP(status *WebsiteStatus, ip string) bool {
+	status.IPChanged = false
+	status.PreviousIP = ""
+	if ip == "" {
+		return false
+	}
+	known := status.KnownIPs
+	if len(known) > 0 && known[len(known)-1] != ip {
+		status.IPChanged = true
+		status.PreviousIP = known[len(known)-1]
+	}
+
+	seen := false
+	kept := make([]string, 0, len(known)+1)
+	for _, knownIP := range known {
+		if knownIP == ip {
+			seen = true
+			continue
+		}
+		kept = append(kept, knownIP)
+	}
+	kept = append(kept, ip)
+	if len(kept) > maxKnownIPs {
+		kept = kept[len(kept)-maxKnownIPs:]
+	}
+	status.KnownIPs = kept
+	return len(known) > 0 && !seen
+}
+
+// Alert 網站狀態轉換的通知內容
+type Alert struct {
+	URL       string
+	OldStatus int
+	NewStatus int
+	Message   string
+	Time      time.Time
+	Up        bool // 轉換後網站是否正常
+
+	Escalation bool          // 持續異常超過 escalation.after 的升級通知
+	DownFor    time.Duration // 升級通知時已經異常的時間
+
+	IPChange   bool   // 連線到新 IP 的通知 (alert_on_ip_change)
+	PreviousIP string // IP 變更前連線的 IP
+	RemoteIP   string // IP 變更後連線的 IP
+}
+
+// Notifier 發送狀態轉換通知的介面
+type Notifier interface {
+	Notify(alert Alert) error
+}
+
+// notifiers 啟動時依設定建立的通知方式
+var notifiers []Notifier
+
+// dispatchAlert 在背景將通知送給所有通知方式，失敗只記錄日誌
+// 由 updateStatus 在持有鎖時呼叫，因此不能同步等待送出
+func dispatchAlert(alert Alert) {
+	dispatchAlertTo(notifiers, alert)
+}
+
+// dispatchAlertTo 在背景將通知送給指定的通知方式
+func dispatchAlertTo(targets []Notifier, alert Alert) {
+	for _, notifier := range targets {
+		go func(notifier Notifier) {
+			if err := notifier.Notify(alert); err != nil {
+				log.Printf("Error sending alert for %s: %v", alert.URL, err)
+			}
+		}(notifier)
+	}
+}
+
+// EscalationConfig 升級通知設定：網站異常超過 After 仍未恢復時再通知一次，
+// 未設定 email 與 webhooks 時送給一般的通知方式
+type EscalationConfig struct {
+	After    Duration        `json:"after"`
+	Email    *EmailConfig    `json:"email,omitempty"`
+	Webhooks []WebhookConfig `json:"webhooks,omitempty"`
+}
+
+// 冷卻時間區分的通知種類，異常與恢復分開計算，恢復通知不會因為剛送出異常通知而被擋下
+const (
+	alertKindDown     = "down"
+	alertKindUp       = "up"
+	alertKindIPChange = "ip_change"
+)
+
+// alertCooldown 一個網址各種通知最近一次送出的時間，與因冷卻而延後的狀態轉換通知
+type alertCooldown struct {
+	last     map[string]time.Time
+	deferred *Alert
+}
+
+// alertCooldowns 各網址的通知冷卻狀態，由 statusMu 保護
+var alertCooldowns = make(map[string]*alertCooldown)
+
+// cooldownFor 返回網址的通知冷卻狀態，不存在時建立，呼叫者需持有 statusMu
+func cooldownFor(url string) *alertCooldown {
+	state, ok := alertCooldowns[url]
+	if !ok {
+		state = &alertCooldown{last: make(map[string]time.Time)}
+		alertCooldowns[url] = state
+	}
+	return state
+}
+
+// cooldownAllows 判斷這一種通知是否已經過了冷卻時間，允許時記錄送出的時間，呼叫者需持有 statusMu
+func cooldownAllows(url, kind string, now time.Time, cooldown time.Duration) bool {
+	state := cooldownFor(url)
+	if last, ok := state.last[kind]; ok && cooldown > 0 && now.Sub(last) < cooldown {
+		return false
+	}
+	state.last[kind] = now
+	return true
+}
+
+// transitionKind 返回狀態轉換通知的種類
+func transitionKind(up bool) string {
+	if up {
+		return alertKindUp
+	}
+	return alertKindDown
+}
+
+// throttleTransition 判斷狀態轉換通知是否立即送出，呼叫者需持有 statusMu；
+// 冷卻期間的通知延後，冷卻結束時網站仍處於該狀態才由 flushDeferredAlert 補發。
+// 延後的通知尚未送出前網站又轉換回來時兩者都不送出，收到的最後一則通知仍與實際狀態相符
+func throttleTransition(alert Alert, cooldown time.Duration) bool {
+	state := cooldownFor(alert.URL)
+	if state.deferred != nil && state.deferred.Up != alert.Up {
+		log.Printf("%s changed back before the deferred alert was sent, dropping both", alert.URL)
+		state.deferred = nil
+		return false
+	}
+	state.deferred = nil
+	if !cooldownAllows(alert.URL, transitionKind(alert.Up), alert.Time, cooldown) {
+		log.Printf("%s alert is within the %v cooldown, deferring it", alert.URL, cooldown)
+		state.deferred = &alert
+		return false
+	}
+	return true
+}
+
+// flushDeferredAlert 冷卻結束且網站仍處於延後通知的狀態時補發通知，呼叫者需持有 statusMu
+func flushDeferredAlert(url string, healthy bool, result CheckResult) {
+	state, ok := alertCooldowns[url]
+	if !ok || state.deferred == nil || state.deferred.Up != healthy {
+		return
+	}
+	if !cooldownAllows(url, transitionKind(healthy), result.CheckedTime, result.AlertCooldown) {
+		return
+	}
+	alert := *state.deferred
+	state.deferred = nil
+	alert.NewStatus = result.Status
+	alert.Message = result.StatusMessage + " (deferred by alert cooldown)"
+	log.Printf("Sending deferred alert for %s", url)
+	dispatchAlert(alert)
+}
+
+// 升級通知的設定與等待中的計時器，escalations 由 statusMu 保護
+var (
+	escalationAfter     time.Duration // 0 代表不啟用升級通知
+	escalationNotifiers []Notifier
+	escalations         = make(map[string]*time.Timer)
+)
+
+// scheduleEscalation 在網站異常時排定升級通知，取代同一網址已排定的通知，呼叫者需持有 statusMu
+func scheduleEscalation(url string, downSince time.Time) {
+	if escalationAfter <= 0 {
+		return
+	}
+	cancelEscalation(url)
+	escalations[url] = time.AfterFunc(escalationAfter, func() {
+		fireEscalation(url, downSince)
+	})
+}
+
+// cancelEscalation 網站恢復時取消等待中的升級通知，呼叫者需持有 statusMu
+func cancelEscalation(url string) {
+	if timer, ok := escalations[url]; ok {
+		timer.Stop()
+		delete(escalations, url)
+		debugf("Cancelled pending escalation for %s", url)
+	}
+}
+
+// fireEscalation 到期時確認網站仍處於同一次異常，才送出升級通知
+func fireEscalation(url string, downSince time.Time) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	// 保留 escalations 中的紀錄直到網站恢復，同一次異常不會再排定升級通知
+	status, ok := currentStatus[url]
+	if !ok || status.Healthy() || !status.DownSince.Equal(downSince) {
+		return
+	}
+	if status.Maintenance {
+		log.Printf("%s is in maintenance, suppressing escalation", url)
+		return
+	}
+
+	downFor := time.Since(downSince).Round(time.Second)
+	log.Printf("%s has been down for %v, escalating", url, downFor)
+	dispatchAlertTo(escalationNotifiers, Alert{
+		URL:        url,
+		OldStatus:  status.Status,
+		NewStatus:  status.Status,
+		Message:    status.StatusMessage,
+		Time:       time.Now(),
+		Escalation: true,
+		DownFor:    downFor,
+	})
+}
+
+// EmailConfig SMTP 郵件通知設定
+type EmailConfig struct {
+	Host     string   `json:"host"`
+	Port     int      `json:"port,omitempty"` // 預設為 587
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// EmailNotifier 以 SMTP 寄送狀態轉換通知
+type EmailNotifier struct {
+	config EmailConfig
+}
+
+// NewEmailNotifier 建立 SMTP 郵件通知
+func NewEmailNotifier(config EmailConfig) *EmailNotifier {
+	if config.Port == 0 {
+		config.Port = 587
+	}
+	return &EmailNotifier{config: config}
+}
+
+// Notify 寄出一封說明狀態轉換的郵件
+func (n *EmailNotifier) Notify(alert Alert) error {
+	state := "DOWN"
+	if alert.Up {
+		state = "UP"
+	}
+	subject := fmt.Sprintf("[Website Monitor] %s is %s", alert.URL, state)
+	if alert.Escalation {
+		subject = fmt.Sprintf("[Website Monitor] %s is still DOWN after %v", alert.URL, alert.DownFor)
+	}
+	if alert.IPChange {
+		subject = fmt.Sprintf("[Website Monitor] %s IP changed from %s to %s", alert.URL, alert.PreviousIP, alert.RemoteIP)
+	}
+	body := fmt.Sprintf("URL: %s\r\nOld status: %d %s\r\nNew status: %d %s\r\nTime: %s\r\n",
+		alert.URL,
+		alert.OldStatus, statusText(alert.OldStatus),
+		alert.NewStatus, alert.Message,
+		alert.Time.Format(time.RFC3339))
+	msg := "From: " + n.config.From + "\r\n" +
+		"To: " + strings.Join(n.config.To, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"\r\n" + body
+
+	var auth smtp.Auth
+	if n.config.Username != "" {
+		auth = smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host)
+	}
+	addr := net.JoinHostPort(n.config.Host, strconv.Itoa(n.config.Port))
+	return smtp.SendMail(addr, auth, n.config.From, n.config.To, []byte(msg))
+}
+
+// WebhookConfig Webhook 通知設定
+type WebhookConfig struct {
+	URL     string   `json:"url"`
+	Format  string   `json:"format,omitempty"`  // "json" (預設) 或 "slack"
+	Timeout Duration `json:"timeout,omitempty"` // 預設為 5 秒
+}
+
+// WebhookNotifier 以 HTTP POST 將狀態轉換送到 Webhook
+type WebhookNotifier struct {
+	config WebhookConfig
+	client *http.Client
+}
+
+// NewWebhookNotifier 建立 Webhook 通知，使用較短的逾時避免拖慢監控
+func NewWebhookNotifier(config WebhookConfig) *WebhookNotifier {
+	if config.Timeout <= 0 {
+		config.Timeout = Duration(defaultWebhookTimeout)
+	}
+	return &WebhookNotifier{
+		config: config,
+		client: &http.Client{Timeout: time.Duration(config.Timeout)},
+	}
+}
+
+// webhookPayload 通用 JSON 格式的 Webhook 內容
+type webhookPayload struct {
+	URL       string    `json:"url"`
+	OldStatus int       `json:"oldStatus"`
+	NewStatus int       `json:"newStatus"`
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+
+	Escalation     bool    `json:"escalation,omitempty"`     // 升級通知
+	DownForSeconds float64 `json:"downForSeconds,omitempty"` // 升級通知時已經異常的秒數
+
+	IPChanged  bool   `json:"ipChanged,omitempty"`  // 連線到新 IP 的通知
+	PreviousIP string `json:"previousIP,omitempty"` // IP 變更前連線的 IP
+	RemoteIP   string `json:"remoteIP,omitempty"`   // IP 變更後連線的 IP
+}
+
+// Notify 將通知以設定的格式 POST 到 Webhook
+func (n *WebhookNotifier) Notify(alert Alert) error {
+	var payload interface{}
+	switch n.config.Format {
+	case "slack":
+		state := ":red_circle: DOWN"
+		if alert.Up {
+			state = ":large_green_circle: UP"
+		}
+		if alert.Escalation {
+			state = fmt.Sprintf(":rotating_light: STILL DOWN after %v", alert.DownFor)
+		}
+		if alert.IPChange {
+			state = fmt.Sprintf(":arrows_counterclockwise: IP CHANGED %s → %s", alert.PreviousIP, alert.RemoteIP)
+		}
+		payload = map[string]string{
+			"text": fmt.Sprintf("%s %s\nStatus: %d → %d %s\nTime: %s",
+				state, alert.URL, alert.OldStatus, alert.NewStatus, alert.Message,
+				alert.Time.Format(time.RFC3339)),
+		}
+	default:
+		payload = webhookPayload{
+			URL:       alert.URL,
+			OldStatus: alert.OldStatus,
+			NewStatus: alert.NewStatus,
+			Message:   alert.Message,
+			Time:      alert.Time,
+
+			Escalation:     alert.Escalation,
+			DownForSeconds: alert.DownFor.Seconds(),
+
+			IPChanged:  alert.IPChange,
+			PreviousIP: alert.PreviousIP,
+			RemoteIP:   alert.RemoteIP,
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(n.config.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// trimHistory 只保留最新的 max 筆歷史紀錄，max 小於等於 0 時不限制
+// 以重新切片的方式捨棄最舊的紀錄，不會修改已被讀取端複製走的元素
+func trimHistory(history []HistoryStatus, max int) []HistoryStatus {
+	if max > 0 && len(history) > max {
+		return history[len(history)-max:]
+	}
+	return history
+}
+
+// responseTimeStats 計算最近 window 筆有回應的紀錄的平均、最短與最長回應時間
+// 連線錯誤沒有實際回應，不列入計算；window 小於等於 0 時計算全部紀錄
+func responseTimeStats(history []HistoryStatus, window int) (avg, min, max time.Duration) {
+	if window > 0 && len(history) > window {
+		history = history[len(history)-window:]
+	}
+
+	var total time.Duration
+	count := 0
+	for _, h := range history {
+		if h.Status == 0 {
+			continue
+		}
+		if count == 0 || h.ResponseTime < min {
+			min = h.ResponseTime
+		}
+		if h.ResponseTime > max {
+			max = h.ResponseTime
+		}
+		total += h.ResponseTime
+		count++
+	}
+	if count == 0 {
+		return 0, 0, 0
+	}
+	return total / time.Duration(count), min, max
+}
+
+// latencyEMAAlpha 回應時間指數移動平均的平滑係數，於啟動時設定
+var latencyEMAAlpha = defaultLatencyEMAAlpha
+
+// nextEMA 以新的回應時間更新指數移動平均，尚未有平均時直接使用該值
+func nextEMA(ema, sample time.Duration, alpha float64) time.Duration {
+	if ema == 0 {
+		return sample
+	}
+	return time.Duration(alpha*float64(sample) + (1-alpha)*float64(ema))
+}
+
+// latencyEMA 依序以歷史紀錄計算回應時間的指數移動平均，不計連線錯誤
+func latencyEMA(history []HistoryStatus, alpha float64) time.Duration {
+	var ema time.Duration
+	for _, h := range history {
+		if h.Status != 0 {
+			ema = nextEMA(ema, h.ResponseTime, alpha)
+		}
+	}
+	return ema
+}
+
+// HistogramBucket 回應時間分佈中的一個區間
+type HistogramBucket struct {
+	Label      string        // 顯示用的區間名稱，例如 "<100ms"、">=3s"
+	UpperBound time.Duration // 區間上限 (不含)，最後一個區間為 0 代表沒有上限
+	Count      int           // 落在此區間的檢查次數
+}
+
+// responseTimeHistogram 計算最近 window 筆歷史紀錄的回應時間分佈，連線錯誤不列入計算
+func responseTimeHistogram(history []HistoryStatus, window int, bounds []time.Duration) []HistogramBucket {
+	if window > 0 && len(history) > window {
+		history = history[len(history)-window:]
+	}
+
+	buckets := make([]HistogramBucket, len(bounds)+1)
+	for i, bound := range bounds {
+		buckets[i] = HistogramBucket{Label: "<" + bound.String(), UpperBound: bound}
+	}
+	if len(bounds) > 0 {
+		buckets[len(bounds)].Label = ">=" + bounds[len(bounds)-1].String()
+	} else {
+		buckets[0].Label = "all"
+	}
+
+	for _, h := range history {
+		if h.Status == 0 {
+			continue
+		}
+		i := sort.Search(len(bounds), func(i int) bool { return h.ResponseTime < bounds[i] })
+		buckets[i].Count++
+	}
+	return buckets
+}
+
+// TimelineBucket 狀態時間軸中的一格
+type TimelineBucket struct {
+	Start    time.Time // 這一格的開始時間
+	State    string    // 這一格內最嚴重的狀態分類，沒有檢查時為空字串
+	Checks   int       // 這一格內的檢查次數
+	Failures int       // 這一格內異常的檢查次數
+}
+
+// timelineSeverity 決定一格內以哪個狀態分類代表，數字越大越嚴重；沒有分類的狀態碼介於警告與錯誤之間
+var timelineSeverity = map[string]int{stateOK: 1, stateRedirect: 2, stateWarning: 3, "": 4, stateError: 5}
+
+// statusTimeline 將歷史紀錄依 bucket 分格，每格以最嚴重的狀態分類代表，沒有檢查的時段也保留為空格，
+// 只返回最近 maxTimelineBuckets 格；格線以 Unix 時間對齊，頁面與 API 看到的分格一致
+func statusTimeline(history []HistoryStatus, bucket time.Duration) []TimelineBucket {
+	if len(history) == 0 || bucket < time.Millisecond {
+		return nil
+	}
+	start := func(t time.Time) time.Time {
+		return time.UnixMilli(t.UnixMilli() / bucket.Milliseconds() * bucket.Milliseconds()).UTC()
+	}
+
+	last := start(history[len(history)-1].CheckedTime)
+	first := start(history[0].CheckedTime)
+	if oldest := last.Add(-bucket * (maxTimelineBuckets - 1)); first.Before(oldest) {
+		first = oldest
+	}
+	buckets := make([]TimelineBucket, int(last.Sub(first)/bucket)+1)
+	for i := range buckets {
+		buckets[i].Start = first.Add(bucket * time.Duration(i))
+	}
+	for _, h := range history {
+		i := int(start(h.CheckedTime).Sub(first) / bucket)
+		if i < 0 || i >= len(buckets) {
+			continue
+		}
+		b := &buckets[i]
+		state := classifyState(h.Status, h.Expected, h.CheckFailed, false)
+		if b.Checks == 0 || timelineSeverity[state] > timelineSeverity[b.State] {
+			b.State = state
+		}
+		b.Checks++
+		if !h.Healthy() {
+			b.Failures++
+		}
+	}
+	return buckets
+}
+
+// 網站狀態分類，頁面上對應 status-<state> 的 CSS class
+const (
+	stateOK       = "ok"
+	stateWarning  = "warning"
+	stateError    = "error"
+	stateDegraded = "degraded" // 回應正常但超過延遲門檻
+	stateRedirect = "redirect" // 3xx 重新導向，未設定 redirects_up 時不算正常
+
+	stateMaintenance = "maintenance" // 處於維護時段，狀態碼仍照實記錄
+	statePending     = "pending"     // 啟動後尚未完成第一次檢查
+)
+
+// StatusRule 狀態碼分類規則，狀態碼在 codes 中或介於 from 與 to 之間 (含) 時分類為 class
+type StatusRule struct {
+	Codes []int  `json:"codes,omitempty"`
+	From  int    `json:"from,omitempty"`
+	To    int    `json:"to,omitempty"`
+	Class string `json:"class"` // ok、warning 或 error
+}
+
+// matches 判斷狀態碼是否符合這條規則
+func (r StatusRule) matches(status int) bool {
+	for _, code := range r.Codes {
+		if status == code {
+			return true
+		}
+	}
+	return r.To > 0 && status >= r.From && status <= r.To
+}
+
+// validate 檢查規則的分類與範圍是否合法
+func (r StatusRule) validate() error {
+	if r.Class != stateOK && r.Class != stateWarning && r.Class != stateError {
+		return fmt.Errorf("class must be ok, warning or error, got %q", r.Class)
+	}
+	if len(r.Codes) == 0 && (r.To <= 0 || r.From > r.To) {
+		return errors.New("rule needs codes or a from/to range")
+	}
+	return nil
+}
+
+// defaultStatusRules 內建的分類規則：2xx 正常、3xx 為重新導向、4xx 警告、5xx 與連線失敗 (0) 為錯誤，
+// 其餘狀態碼 (例如 1xx) 沒有分類且視為異常
+var defaultStatusRules = []StatusRule{
+	{From: 200, To: 299, Class: stateOK},
+	{From: 300, To: 399, Class: stateRedirect},
+	{From: 400, To: 499, Class: stateWarning},
+	{From: 500, To: 999, Class: stateError},
+	{Codes: []int{0}, Class: stateError},
+}
+
+// statusRules 設定檔中的分類規則，優先於內建規則，依順序採用第一條符合的規則
+var statusRules []StatusRule
+
+// redirectsUp 是否將 3xx 重新導向視為正常，預設只有 2xx 正常
+var redirectsUp bool
+
+// classifyStatus 依分類規則分類狀態碼，沒有符合的規則時返回空字串
+// 頁面上的顏色、isUp 的正常判斷與通知都使用這個結果
+func classifyStatus(status int) string {
+	for _, rules := range [][]StatusRule{statusRules, defaultStatusRules} {
+		for _, rule := range rules {
+			if !rule.matches(status) {
+				continue
+			}
+			if rule.Class == stateRedirect && redirectsUp {
+				return stateOK
+			}
+			return rule.Class
+		}
+	}
+	return ""
+}
+
+// classifyState 綜合狀態碼、預期狀態碼、內容檢查與回應速度決定網站狀態
+func classifyState(status int, expected, checkFailed, slow bool) string {
+	if checkFailed {
+		return stateError
+	}
+	state := classifyStatus(status)
+	if expected {
+		state = stateOK
+	}
+	if state == stateOK && slow {
+		return stateDegraded
+	}
+	return state
+}
+
+// Incident 一段網站持續異常的期間
+type Incident struct {
+	Start         time.Time     // 第一筆異常紀錄的時間
+	End           time.Time     // 恢復正常的時間，仍在異常中為零值
+	Ongoing       bool          // 是否仍在異常中
+	Duration      time.Duration // 持續時間，仍在異常中時為到目前為止的時間
+	DurationText  string        // 可讀的持續時間，仍在異常中時標示 ongoing
+	Status        int           // 異常期間第一筆紀錄的狀態碼
+	StatusMessage string
+	Checks        int // 異常期間的檢查次數
+}
+
+// findIncidents 掃描歷史紀錄，將連續的異常紀錄合併為事件
+func findIncidents(history []HistoryStatus, now time.Time) []Incident {
+	incidents := []Incident{}
+	var open *Incident
+	for _, h := range history {
+		if !h.Healthy() {
+			if open == nil {
+				open = &Incident{Start: h.CheckedTime, Status: h.Status, StatusMessage: h.StatusMessage}
+			}
+			open.Checks++
+			continue
+		}
+		if open != nil {
+			open.End = h.CheckedTime
+			open.Duration = open.End.Sub(open.Start)
+			open.DurationText = open.Duration.Round(time.Second).String()
+			incidents = append(incidents, *open)
+			open = nil
+		}
+	}
+	if open != nil {
+		open.Ongoing = true
+		open.Duration = now.Sub(open.Start)
+		open.DurationText = "ongoing (" + open.Duration.Round(time.Second).String() + ")"
+		incidents = append(incidents, *open)
+	}
+	return incidents
+}
+
+// isFlapping 計算最近 flapWindow 筆紀錄中正常與異常之間的切換次數，
+// 達到 flapThreshold 時視為頻繁切換；flapThreshold 小於等於 0 時不偵測
+func isFlapping(history []HistoryStatus) bool {
+	if flapThreshold <= 0 {
+		return false
+	}
+	if len(history) > flapWindow {
+		history = history[len(history)-flapWindow:]
+	}
+
+	transitions := 0
+	for i := 1; i < len(history); i++ {
+		if history[i].Healthy() != history[i-1].Healthy() {
+			transitions++
+		}
+	}
+	return transitions >= flapThreshold
+}
+
+// isUp 判斷狀態碼是否代表網站正常，與頁面顏色使用同一套分類規則 (預設為 2xx)
+func isUp(status int) bool {
+	return classifyStatus(status) == stateOK
+}
+
+// uptimePercentage 計算歷史紀錄中正常檢查所佔的百分比
+// window 大於 0 時只計算最近 window 筆紀錄
+func uptimePercentage(history []HistoryStatus, window int) float64 {
+	if window > 0 && len(history) > window {
+		history = history[len(history)-window:]
+	}
+	if len(history) == 0 {
+		return 0
+	}
+
+	up := 0
+	for _, h := range history {
+		if h.Healthy() {
+			up++
+		}
+	}
+	return float64(up) / float64(len(history)) * 100
+}
+
+// WindowUptime 一段固定時間內的正常運作百分比，歷史紀錄不足整段時間時 Partial 為 true，
+// Uptime 以現有的紀錄計算，Covered 為實際涵蓋的時間
+type WindowUptime struct {
+	Label   string
+	Uptime  float64
+	Checks  int           // 時間範圍內的檢查次數
+	Covered time.Duration // 最早一筆紀錄距今的時間，最長為整段時間
+	Partial bool
+}
+
+// uptimeWindows 計算 SLA 式正常運作百分比的固定時間範圍
+var uptimeWindows = []struct {
+	label  string
+	length time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// windowUptimes 依 CheckedTime 計算截至 now 的每個固定時間範圍的正常運作百分比
+// 歷史紀錄受 max_history 限制，較長的範圍可能只有部分資料
+func windowUptimes(history []HistoryStatus, now time.Time) []WindowUptime {
+	if len(history) == 0 {
+		return nil
+	}
+	oldest := history[0].CheckedTime
+	results := make([]WindowUptime, 0, len(uptimeWindows))
+	for _, window := range uptimeWindows {
+		since := now.Add(-window.length)
+		result := WindowUptime{Label: window.label, Covered: window.length}
+		if oldest.After(since) {
+			result.Partial = true
+			result.Covered = now.Sub(oldest)
+		}
+		up := 0
+		for _, h := range history {
+			if h.CheckedTime.Before(since) {
+				continue
+			}
+			result.Checks++
+			if h.Healthy() {
+				up++
+			}
+		}
+		if result.Checks > 0 {
+			result.Uptime = float64(up) / float64(result.Checks) * 100
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// 定期將有變更的歷史資料保存，直到 ctx 被取消
+// 避免每次檢查都重寫整個歷史檔案
+func flushHistoryPeriodically(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			flushHistory()
+		}
+	}
+}
+
+// flushHistory 在歷史資料有變更時交由 store 保存
+func flushHistory() {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	if !historyDirty {
+		return
+	}
+	if err := store.Save(currentStatus); err != nil {
+		log.Printf("Error saving history: %v", err)
+		return
+	}
+	historyDirty = false
+}
+
+// Store 歷史資料的儲存方式
+type Store interface {
+	// Load 讀取先前保存的網站狀態
+	Load() (map[string]WebsiteStatus, error)
+	// Record 在每次檢查後呼叫，保存新增的一筆紀錄
+	Record(url string, entry HistoryStatus) error
+	// Save 保存整份目前狀態，呼叫者需持有 statusMu 的寫入鎖
+	Save(statuses map[string]WebsiteStatus) error
+	// Close 關閉儲存並釋放資源
+	Close() error
+}
+
+// HistoryQuerier 可依時間範圍查詢歷史紀錄的儲存方式
+type HistoryQuerier interface {
+	History(url string, since time.Time, limit int) ([]HistoryStatus, error)
+}
+
+// HistoryClearer 在 Save 之外另外保存歷史紀錄的儲存方式，清除紀錄時需要一併刪除
+type HistoryClearer interface {
+	ClearHistory(url string) error
+}
+
+// store 目前使用的歷史資料儲存方式，於啟動時設定
+var store Store = NewJSONFileStore(defaultHistoryFile)
+
+// JSONFileStore 將所有網站狀態以單一 JSON 檔案保存，可選擇以 gzip 壓縮
+type JSONFileStore struct {
+	path     string
+	compress bool // 以 gzip 壓縮寫入，檔名為 path 加上 .gz
+
+	// migratedFrom 從另一種格式的檔案讀取時記錄其路徑，第一次保存成功後刪除，避免之後讀到過期的資料
+	migratedFrom string
+
+	// newerSchema 檔案是由較新版本寫入的，為了不遺失資料而拒絕覆寫
+	newerSchema bool
+
+	// keepCorrupt 檔案無法解析且無法改名保留，為了保留原始資料而拒絕覆寫
+	keepCorrupt bool
+}
+
+// historySchemaVersion 歷史檔案目前的格式版本
+// 版本 1 為沒有外層結構、直接以網址為鍵的狀態物件；版本 2 起以 historyFile 包裝；
+// 版本 3 起歷史紀錄的時間為毫秒精度字串、回應時間為整數毫秒，舊版程式無法正確讀取
+const historySchemaVersion = 3
+
+// historyFile 歷史檔案的外層結構
+type historyFile struct {
+	SchemaVersion int                      `json:"schema_version"`
+	Statuses      map[string]WebsiteStatus `json:"statuses"`
+}
+
+// NewJSONFileStore 建立以 JSON 檔案保存的 Store
+func NewJSONFileStore(path string) *JSONFileStore {
+	return &JSONFileStore{path: path}
+}
+
+// NewCompressedJSONFileStore 建立以 gzip 壓縮的 JSON 檔案保存的 Store，檔名為 path 加上 .gz
+func NewCompressedJSONFileStore(path string) *JSONFileStore {
+	return &JSONFileStore{path: path + ".gz", compress: true}
+}
+
+// gzipMagic gzip 檔案開頭的兩個位元組
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Load 從檔案讀取歷史資料，依檔案開頭判斷是否為 gzip 壓縮
+// 檔案不存在時改讀另一種格式的檔案 (壓縮時讀未壓縮的檔案，反之亦然)，
+// 切換是否壓縮時不需要手動轉換，下次保存時寫入新的格式
+func (s *JSONFileStore) Load() (map[string]WebsiteStatus, error) {
+	path := s.path
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		other := strings.TrimSuffix(s.path, ".gz")
+		if !s.compress {
+			other = s.path + ".gz"
+		}
+		if otherFile, otherErr := os.Open(other); otherErr == nil {
+			log.Printf("History file %s not found, loading %s instead; history will be saved to %s", s.path, other, s.path)
+			path, file, err = other, otherFile, nil
+			s.migratedFrom = other
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening history file: %w", err)
+	}
+
+	statuses, err := s.decode(path, file)
+	file.Close()
+	if err != nil && !s.newerSchema {
+		return s.quarantine(path, err)
+	}
+	return statuses, err
+}
+
+// quarantine 將無法解析的歷史檔案加上時間戳改名保留，以空的歷史紀錄繼續執行，
+// 之後的保存不會覆蓋損壞的檔案；改名失敗時返回錯誤並拒絕覆寫
+func (s *JSONFileStore) quarantine(path string, cause error) (map[string]WebsiteStatus, error) {
+	aside := fmt.Sprintf("%s.corrupt-%s", path, time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.Rename(path, aside); err != nil {
+		s.keepCorrupt = true
+		return nil, fmt.Errorf("%w; moving the corrupt file aside failed, not overwriting it: %v", cause, err)
+	}
+	if s.migratedFrom == path {
+		s.migratedFrom = ""
+	}
+	log.Printf("History file %s is corrupt (%v), moved it to %s and starting with empty history", path, cause, aside)
+	fmt.Fprintf(os.Stderr, "歷史檔案 %s 無法解析，已改名為 %s 並以空的歷史紀錄開始: %v\n", path, aside, cause)
+	return map[string]WebsiteStatus{}, nil
+}
+
+// decode 解析歷史檔案的內容，自動偵測 gzip 壓縮並轉換舊版的格式
+func (s *JSONFileStore) decode(path string, file io.Reader) (map[string]WebsiteStatus, error) {
+	reader := bufio.NewReader(file)
+	var input io.Reader = reader
+	if magic, _ := reader.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing history file %s: %w", path, err)
+		}
+		defer gz.Close()
+		input = gz
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(input).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("decoding history from file: %w", err)
+	}
+
+	// 沒有 schema_version 的檔案為版本 1，網址不可能剛好是這個鍵
+	version := 1
+	if rawVersion, ok := raw["schema_version"]; ok {
+		if err := json.Unmarshal(rawVersion, &version); err != nil {
+			return nil, fmt.Errorf("decoding history schema version: %w", err)
+		}
+	}
+
+	switch {
+	case version > historySchemaVersion:
+		s.newerSchema = true
+		return nil, fmt.Errorf("history file %s uses schema version %d, newer than supported version %d; refusing to overwrite it", s.path, version, historySchemaVersion)
+	case version < historySchemaVersion:
+		log.Printf("Upgrading history file %s from schema version %d to %d", s.path, version, historySchemaVersion)
+	}
+	return migrateHistory(version, raw)
+}
+
+// migrateHistory 將各版本的歷史檔案內容轉換為目前的狀態結構
+func migrateHistory(version int, raw map[string]json.RawMessage) (map[string]WebsiteStatus, error) {
+	statuses := make(map[string]WebsiteStatus)
+	switch version {
+	case 1:
+		// 版本 1 的每個鍵都是網址，欄位與目前的 WebsiteStatus 相容，缺少的欄位由 loadHistory 補上
+		for url, data := range raw {
+			var status WebsiteStatus
+			if err := json.Unmarshal(data, &status); err != nil {
+				return nil, fmt.Errorf("decoding history for %s: %w", url, err)
+			}
+			statuses[url] = status
+		}
+	default:
+		// 版本 2 與 3 的歷史紀錄格式差異由 HistoryStatus.UnmarshalJSON 處理
+		if err := json.Unmarshal(raw["statuses"], &statuses); err != nil {
+			return nil, fmt.Errorf("decoding history from file: %w", err)
+		}
+	}
+	return statuses, nil
+}
+
+// Record 不做任何事，JSON 檔案由 Save 定期整份寫入
+func (s *JSONFileStore) Record(url string, entry HistoryStatus) error {
+	return nil
+}
+
+// Save 保存歷史資料到檔案
+// 先寫入同目錄下的暫存檔，成功後才以 os.Rename 取代正式檔案，
+// 寫入途中當機也不會留下被截斷的歷史檔案
+func (s *JSONFileStore) Save(statuses map[string]WebsiteStatus) error {
+	if s.newerSchema {
+		return fmt.Errorf("history file %s was written by a newer version, not overwriting it", s.path)
+	}
+	if s.keepCorrupt {
+		return fmt.Errorf("history file %s is corrupt and could not be moved aside, not overwriting it", s.path)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating temporary history file: %w", err)
+	}
+	tmpName := file.Name()
+
+	var output io.Writer = file
+	var gz *gzip.Writer
+	if s.compress {
+		gz = gzip.NewWriter(file)
+		output = gz
+	}
+	encoder := json.NewEncoder(output)
+	err = encoder.Encode(historyFile{SchemaVersion: historySchemaVersion, Statuses: statuses})
+	if gz != nil {
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("writing history file: %w", err)
+	}
+
+	if err := os.Rename(tmpName, s.path); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("replacing history file: %w", err)
+	}
+	if s.migratedFrom != "" {
+		if err := os.Remove(s.migratedFrom); err == nil {
+			log.Printf("History migrated to %s, removed %s", s.path, s.migratedFrom)
+		}
+		s.migratedFrom = ""
+	}
+	return nil
+}
+
+// Close 不需要釋放任何資源
+func (s *JSONFileStore) Close() error {
+	return nil
+}
+
+// MemoryStore 不寫入任何檔案，所有狀態只保存在 currentStatus，重新啟動後歷史資料會遺失
+type MemoryStore struct{}
+
+// Load 沒有先前保存的資料，返回空的狀態
+func (MemoryStore) Load() (map[string]WebsiteStatus, error) {
+	return map[string]WebsiteStatus{}, nil
+}
+
+// Record 不做任何事
+func (MemoryStore) Record(url string, entry HistoryStatus) error {
+	return nil
+}
+
+// Save 不做任何事
+func (MemoryStore) Save(statuses map[string]WebsiteStatus) error {
+	return nil
+}
+
+// Close 不做任何事
+func (MemoryStore) Close() error {
+	return nil
+}
+
+// SQLiteStore 將每筆檢查紀錄存放在 SQLite 資料表中
+// 需要以 -tags sqlite 編譯才會註冊 sqlite3 驅動程式
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore 開啟 SQLite 資料庫並建立歷史紀錄資料表
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w (build with -tags sqlite to enable SQLite storage)", path, err)
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS history (
+		url           TEXT    NOT NULL,
+		status        INTEGER NOT NULL,
+		message       TEXT    NOT NULL,
+		checked_time  INTEGER NOT NULL, -- Unix 奈秒
+		response_time INTEGER NOT NULL, -- 奈秒
+		check_failed  INTEGER NOT NULL DEFAULT 0,
+		expected      INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS history_url_time ON history (url, checked_time);`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating history table: %w", err)
+	}
+	// 舊版建立的資料表沒有 expected 欄位，補上後舊紀錄預設為 0
+	_, err = db.Exec(`ALTER TABLE history ADD COLUMN expected INTEGER NOT NULL DEFAULT 0`)
+	if err != nil && !strings.Contains(err.Error(), "duplicate column") {
+		db.Close()
+		return nil, fmt.Errorf("migrating history table: %w", err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// Load 讀取每個網址最新的 maxHistory 筆紀錄並重建目前狀態
+func (s *SQLiteStore) Load() (map[string]WebsiteStatus, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT url FROM history`)
+	if err != nil {
+		return nil, fmt.Errorf("reading history from database: %w", err)
+	}
+	var storedURLs []string
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("reading history from database: %w", err)
+		}
+		storedURLs = append(storedURLs, url)
+	}
+	rows.Close()
+
+	statuses := make(map[string]WebsiteStatus)
+	for _, url := range storedURLs {
+		history, err := s.History(url, time.Time{}, maxHistory)
+		if err != nil {
+			return nil, fmt.Errorf("reading history for %s from database: %w", url, err)
+		}
+		if len(history) > 0 {
+			statuses[url] = rebuildStatus(url, history)
+		}
+	}
+	return statuses, nil
+}
+
+// Record 將一筆檢查紀錄寫入資料庫，每次檢查只新增一列
+func (s *SQLiteStore) Record(url string, entry HistoryStatus) error {
+	_, err := s.db.Exec(`INSERT INTO history (url, status, message, checked_time, response_time, check_failed, expected) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		url, entry.Status, entry.StatusMessage, entry.CheckedTime.UnixNano(), int64(entry.ResponseTime), entry.CheckFailed, entry.Expected)
+	return err
+}
+
+// ClearHistory 刪除單一網址的所有紀錄
+func (s *SQLiteStore) ClearHistory(url string) error {
+	_, err := s.db.Exec(`DELETE FROM history WHERE url = ?`, url)
+	return err
+}
+
+// Save 不做任何事，紀錄已在 Record 時寫入
+func (s *SQLiteStore) Save(statuses map[string]WebsiteStatus) error {
+	return nil
+}
+
+// Close 關閉資料庫連線
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// History 查詢單一網址在 since 之後的紀錄，limit 大於 0 時只返回最新的幾筆
+func (s *SQLiteStore) History(url string, since time.Time, limit int) ([]HistoryStatus, error) {
+	var sinceNano int64
+	if !since.IsZero() {
+		sinceNano = since.UnixNano()
+	}
+	query := `SELECT status, message, checked_time, response_time, check_failed, expected FROM history
+		WHERE url = ? AND checked_time >= ? ORDER BY checked_time DESC`
+	args := []interface{}{url, sinceNano}
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []HistoryStatus{}
+	for rows.Next() {
+		var entry HistoryStatus
+		var checkedTime, responseTime int64
+		err := rows.Scan(&entry.Status, &entry.StatusMessage, &checkedTime, &responseTime, &entry.CheckFailed, &entry.Expected)
+		if err != nil {
+			return nil, err
+		}
+		entry.CheckedTime = time.Unix(0, checkedTime)
+		entry.ResponseTime = time.Duration(responseTime)
+		history = append(history, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// 查詢時由新到舊排序以便套用 LIMIT，返回前改回由舊到新
+	for i, j := 0, len(history)-1; i < j; i, j = i+1, j-1 {
+		history[i], history[j] = history[j], history[i]
+	}
+	return history, nil
+}
+
+// rebuildStatus 由歷史紀錄重建網站狀態，目前狀態取自最新一筆紀錄
+func rebuildStatus(url string, history []HistoryStatus) WebsiteStatus {
+	last := history[len(history)-1]
+	status := WebsiteStatus{
+		URL:             url,
+		Status:          last.Status,
+		StatusMessage:   last.StatusMessage,
+		LastChecked:     last.CheckedTime,
+		ResponseTime:    last.ResponseTime,
+		CheckFailed:     last.CheckFailed,
+		Expected:        last.Expected,
+		ErrorKind:       last.ErrorKind,
+		State:           classifyState(last.Status, last.Expected, last.CheckFailed, false),
+		HistoryStatuses: history,
+	}
+	status.LastSeenUp = lastSeenUp(history)
+	status.Uptime = uptimePercentage(history, uptimeWindow)
+	status.UptimeWindows = windowUptimes(history, time.Now())
+	status.AvgResponseTime, status.MinResponseTime, status.MaxResponseTime = responseTimeStats(history, statsWindow)
+	status.LatencyEMA = latencyEMA(history, latencyEMAAlpha)
+	status.Histogram = responseTimeHistogram(history, statsWindow, histogramBuckets)
+	status.Timeline = statusTimeline(history, timelineBucket)
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].ChangedContent {
+			status.ContentChangedAt = history[i].CheckedTime
+			break
+		}
+	}
+	return status
+}
+
+// lastSeenUp 返回歷史紀錄中最後一筆正常紀錄的時間，沒有正常紀錄時為零值
+func lastSeenUp(history []HistoryStatus) time.Time {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Healthy() {
+			return history[i].CheckedTime
+		}
+	}
+	return time.Time{}
+}
+
+// loadHistory 從 store 讀取歷史資料到 currentStatus
+func loadHistory() {
+	statuses, err := store.Load()
+	if err != nil {
+		log.Printf("Error loading history: %v", err)
+		return
+	}
+
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	for url, status := range statuses {
+		// 舊資料可能超過目前的筆數上限，載入時一併裁切
+		status.HistoryStatuses = trimHistory(status.HistoryStatuses, maxHistory)
+		if status.State == "" {
+			// 舊檔案沒有狀態分類，依狀態碼補上
+			status.State = classifyState(status.Status, status.Expected, status.CheckFailed, false)
+		}
+		if status.LastSeenUp.IsZero() {
+			// 舊檔案沒有最後正常時間，由歷史紀錄補上
+			status.LastSeenUp = lastSeenUp(status.HistoryStatuses)
+		}
+		if status.LatencyEMA == 0 {
+			// 舊檔案沒有移動平均，由歷史紀錄補上
+			status.LatencyEMA = latencyEMA(status.HistoryStatuses, latencyEMAAlpha)
+		}
+		// 距離上次執行可能已經過一段時間，固定時間範圍的正常運作百分比以現在重新計算
+		status.UptimeWindows = windowUptimes(status.HistoryStatuses, time.Now())
+		currentStatus[url] = status
+	}
+}
+
+// seedPendingStatuses 為還沒有任何狀態的網址建立 pending 狀態，
+// 讓頁面在第一次檢查完成前顯示「等待中」而不是空白或看起來像異常的 0
+func seedPendingStatuses(targets []URLConfig) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	for _, target := range targets {
+		if _, ok := currentStatus[target.URL]; ok {
+			continue
+		}
+		currentStatus[target.URL] = WebsiteStatus{
+			URL:           target.URL,
+			Name:          target.Name,
+			Group:         target.Group,
+			Critical:      target.Critical,
+			Tags:          target.Tags,
+			StatusMessage: "Pending",
+			Kind:          checkKind(target.URL),
+			State:         statePending,
+		}
+	}
+}
+
+// templateFuncs 主頁模板使用的函數
+var templateFuncs = template.FuncMap{
+	"statusClass": func(status WebsiteStatus) string {
+		if status.State == "" {
+			return ""
+		}
+		return "status-" + status.State
+	},
+	"contentLength": func(length int64) string {
+		if length < 0 {
+			return "unknown"
+		}
+		return strconv.FormatInt(length, 10) + " bytes"
+	},
+	"percent": func(fraction float64) string {
+		return fmt.Sprintf("%.0f%%", fraction*100)
+	},
+	"downFor": func(status WebsiteStatus) string {
+		if status.Healthy() || status.State == statePending {
+			return ""
+		}
+		if status.LastSeenUp.IsZero() {
+			return "never seen up"
+		}
+		return "down for " + time.Since(status.LastSeenUp).Round(time.Second).String()
+	},
+	"incidents": func(history []HistoryStatus) []Incident {
+		return findIncidents(history, time.Now())
+	},
+	"toJson": toJson, // 註冊自定義 JSON 序列化函數
+}
+
+// indexTemplate 啟動時解析一次的主頁模板，之後每個請求重複使用
+var indexTemplate *template.Template
+
+// embeddedWeb 編譯時內嵌的主頁模板與靜態檔案，執行檔不需要搭配其他檔案
+//
+//go:embed index.html all:static
+var embeddedWeb embed.FS
+
+// overlayFS 先從覆寫目錄讀取檔案，目錄中沒有該檔案時改用內嵌的檔案，
+// 可以只覆寫 index.html 或部分靜態檔案
+type overlayFS struct {
+	override fs.FS
+	embedded fs.FS
+}
+
+// Open 實作 fs.FS
+func (o overlayFS) Open(name string) (fs.File, error) {
+	file, err := o.override.Open(name)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return file, err
+	}
+	return o.embedded.Open(name)
+}
+
+// newWebFS 返回以 dir 覆寫內嵌檔案的檔案系統
+func newWebFS(dir string) fs.FS {
+	return overlayFS{override: os.DirFS(dir), embedded: embeddedWeb}
+}
+
+// loadTemplate 從 fsys 解析主頁模板，語法錯誤時返回錯誤，而不是讓伺服器 panic
+func loadTemplate(fsys fs.FS, name string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).ParseFS(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("parsing template %s: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// 處理主頁請求
+func indexHandler(w http.ResponseWriter, r *http.Request) {
+	// 讀取當前網站狀態，依 ?sort= 排序並依 ?page=、?size= 分頁
+	options, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// 依 ?tag= 篩選後，總覽與分頁都只計算符合的網址
+	tags, err := parseTagFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	allStatuses := tags.apply(snapshotStatuses())
+	websiteStatuses := options.apply(allStatuses)
+
+	// 各網址的回應時間分佈與狀態時間軸，交給頁面上的 script 繪製
+	histograms := make(map[string][]HistogramBucket, len(websiteStatuses))
+	timelines := make(map[string][]TimelineBucket, len(websiteStatuses))
+	for _, status := range websiteStatuses {
+		histograms[status.URL] = status.Histogram
+		timelines[status.URL] = status.Timeline
+	}
+
+	// 所有網址 (不只目前頁數) 的狀態分類，頁面收到更新時據此重新計算總覽
+	states := make(map[string]string, len(allStatuses))
+	for _, status := range allStatuses {
+		states[status.URL] = status.State
+	}
+
+	data := struct {
+		WebsiteStatuses []WebsiteStatus
+		Histograms      map[string][]HistogramBucket
+		Timelines       map[string][]TimelineBucket
+		Summary         Summary
+		States          map[string]string
+		Options         listOptions
+		Tags            tagFilter
+		TagQuery        template.URL // ?tag= 篩選條件的查詢字串，附加在排序與分頁連結後，已經過編碼
+		SortKeys        []string
+		TotalPages      int
+		PrevPage        int // 上一頁的頁數，沒有上一頁時為 0
+		NextPage        int // 下一頁的頁數，沒有下一頁時為 0
+		Build           BuildInfo
+	}{
+		WebsiteStatuses: websiteStatuses,
+		Histograms:      histograms,
+		Timelines:       timelines,
+		Summary:         summarize(allStatuses),
+		States:          states,
+		Options:         options,
+		Tags:            tags,
+		TagQuery:        template.URL(tags.query()),
+		SortKeys:        []string{"url", "name", "group", "status", "response_time", "last_checked"},
+		TotalPages:      options.totalPages(len(allStatuses)),
+		Build:           buildInfo(),
+	}
+	if options.Page > 1 {
+		data.PrevPage = options.Page - 1
+	}
+	if options.Page < data.TotalPages {
+		data.NextPage = options.Page + 1
+	}
+
+	err = indexTemplate.Execute(w, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// snapshotStatuses 在讀取鎖保護下複製一份目前的網站狀態，依網址排序，
+// 避免 map 的迭代順序讓每次返回的順序都不同
+func snapshotStatuses() []WebsiteStatus {
+	statusMu.RLock()
+	defer statusMu.RUnlock()
+
+	websiteStatuses := make([]WebsiteStatus, 0, len(currentStatus))
+	for _, status := range currentStatus {
+		websiteStatuses = append(websiteStatuses, status)
+	}
+	sort.Slice(websiteStatuses, func(i, j int) bool {
+		return websiteStatuses[i].URL < websiteStatuses[j].URL
+	})
+	return websiteStatuses
+}
+
+// Summary 所有網址依狀態分類 (與每列的顏色相同) 的數量
+type Summary struct {
+	Total       int
+	Up          int // ok
+	Degraded    int
+	Redirect    int // 3xx，設定 redirects_up 時計入 Up
+	Warning     int
+	Down        int // error
+	Maintenance int
+	Pending     int // 尚未完成第一次檢查
+	Other       int // 沒有符合任何分類規則的狀態碼，預設為 1xx
+}
+
+// summarize 依 State 統計各分類的網址數量
+func summarize(statuses []WebsiteStatus) Summary {
+	var summary Summary
+	for _, status := range statuses {
+		summary.add(status)
+	}
+	return summary
+}
+
+// add 將一個網址計入總覽
+func (s *Summary) add(status WebsiteStatus) {
+	s.Total++
+	switch status.State {
+	case stateOK:
+		s.Up++
+	case stateDegraded:
+		s.Degraded++
+	case stateRedirect:
+		s.Redirect++
+	case stateWarning:
+		s.Warning++
+	case stateError:
+		s.Down++
+	case stateMaintenance:
+		s.Maintenance++
+	case statePending:
+		s.Pending++
+	default:
+		s.Other++
+	}
+}
+
+// stateFilters ?state= 可用的值與對應的狀態分類，down 與總覽相同代表 error
+var stateFilters = map[string]string{
+	"ok":          stateOK,
+	"degraded":    stateDegraded,
+	"redirect":    stateRedirect,
+	"warning":     stateWarning,
+	"down":        stateError,
+	"error":       stateError,
+	"maintenance": stateMaintenance,
+	"pending":     statePending,
+}
+
+// tagFilter ?tag= 篩選條件，可重複指定多個標籤；預設必須有全部的標籤，?tag_mode=any 時有任一個即可
+type tagFilter struct {
+	Tags []string
+	Any  bool
+}
+
+// parseTagFilter 解析 ?tag= 與 ?tag_mode= 參數，沒有 ?tag= 時返回空的篩選條件
+func parseTagFilter(query neturl.Values) (tagFilter, error) {
+	filter := tagFilter{Tags: cleanTags(query["tag"])}
+	switch query.Get("tag_mode") {
+	case "", "all":
+	case "any":
+		filter.Any = true
+	default:
+		return filter, errors.New("invalid tag_mode parameter, expected all or any")
+	}
+	return filter, nil
+}
+
+// matches 判斷網址的標籤是否符合篩選條件，沒有指定標籤時都符合
+func (f tagFilter) matches(tags []string) bool {
+	if len(f.Tags) == 0 {
+		return true
+	}
+	for _, tag := range f.Tags {
+		if slices.Contains(tags, tag) {
+			if f.Any {
+				return true
+			}
+		} else if !f.Any {
+			return false
+		}
+	}
+	return !f.Any
+}
+
+// apply 只保留標籤符合篩選條件的網址
+func (f tagFilter) apply(statuses []WebsiteStatus) []WebsiteStatus {
+	if len(f.Tags) == 0 {
+		return statuses
+	}
+	filtered := []WebsiteStatus{}
+	for _, status := range statuses {
+		if f.matches(status.Tags) {
+			filtered = append(filtered, status)
+		}
+	}
+	return filtered
+}
+
+// query 返回篩選條件的查詢字串 (以 & 開頭)，讓頁面上的排序與分頁連結保留篩選條件
+func (f tagFilter) query() string {
+	if len(f.Tags) == 0 {
+		return ""
+	}
+	values := neturl.Values{"tag": f.Tags}
+	if f.Any {
+		values.Set("tag_mode", "any")
+	}
+	return "&" + values.Encode()
+}
+
+// filterByState 只保留狀態分類為 state 的網址
+func filterByState(statuses []WebsiteStatus, state string) []WebsiteStatus {
+	filtered := []WebsiteStatus{}
+	for _, status := range statuses {
+		if status.State == state {
+			filtered = append(filtered, status)
+		}
+	}
+	return filtered
+}
+
+// 處理 /api/summary 請求，以 JSON 返回所有網址的狀態總覽
+func apiSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, summarize(snapshotStatuses()))
+}
+
+// MetricsSummary /api/metrics-summary 返回的整體數字，欄位與 Summary 相同並加上整體的回應時間與正常運作百分比
+type MetricsSummary struct {
+	Summary
+	Healthy           int       // 最近一次檢查正常的網址數 (包含符合 expected_status 的狀態碼)
+	AvgResponseTimeMs float64   // 已完成檢查的網址在 stats_window 中平均回應時間的平均 (毫秒)
+	Uptime            float64   // 已完成檢查的網址正常運作百分比的平均 (0-100)，沒有任何網址完成檢查時為 0
+	LastCheck         time.Time // 最近一次完成檢查的時間 (任何網址)
+}
+
+// 處理 /api/metrics-summary 請求，在讀取鎖內直接計算，不複製或排序狀態清單
+func apiMetricsSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	var summary MetricsSummary
+	var totalResponse time.Duration
+	var totalUptime float64
+	checked := 0
+
+	statusMu.RLock()
+	for _, status := range currentStatus {
+		summary.add(status)
+		if status.Healthy() {
+			summary.Healthy++
+		}
+		if status.State == statePending || len(status.HistoryStatuses) == 0 {
+			continue
+		}
+		checked++
+		totalResponse += status.AvgResponseTime
+		totalUptime += status.Uptime
+	}
+	summary.LastCheck = lastCheckTime
+	statusMu.RUnlock()
+
+	if checked > 0 {
+		summary.AvgResponseTimeMs = float64(totalResponse.Microseconds()) / 1000 / float64(checked)
+		summary.Uptime = totalUptime / float64(checked)
+	}
+	writeJSON(w, summary)
+}
+
+// versionHandler 以 JSON 返回目前執行中的版本資訊
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, buildInfo())
+}
+
+// statusSorters ?sort= 可用的排序方式，未指定時依網址排序
+var statusSorters = map[string]func(a, b WebsiteStatus) bool{
+	"url":           func(a, b WebsiteStatus) bool { return a.URL < b.URL },
+	"name":          func(a, b WebsiteStatus) bool { return a.displayName() < b.displayName() },
+	"group":         func(a, b WebsiteStatus) bool { return a.Group < b.Group },
+	"status":        func(a, b WebsiteStatus) bool { return a.Status < b.Status },
+	"response_time": func(a, b WebsiteStatus) bool { return a.ResponseTime < b.ResponseTime },
+	"last_checked":  func(a, b WebsiteStatus) bool { return a.LastChecked.Before(b.LastChecked) },
+}
+
+// listOptions 狀態清單的排序與分頁參數
+type listOptions struct {
+	Sort string // statusSorters 中的排序方式
+	Page int    // 目前頁數，從 1 開始
+	Size int    // 每頁筆數，0 代表不分頁
+}
+
+// parseListOptions 解析 ?sort=、?page= 與 ?size= 參數
+func parseListOptions(query neturl.Values) (listOptions, error) {
+	options := listOptions{Sort: "url", Page: 1}
+	if raw := query.Get("sort"); raw != "" {
+		if _, ok := statusSorters[raw]; !ok {
+			return options, fmt.Errorf("invalid sort parameter %q, expected url, name, group, status, response_time or last_checked", raw)
+		}
+		options.Sort = raw
+	}
+	if raw := query.Get("page"); raw != "" {
+		page, err := strconv.Atoi(raw)
+		if err != nil || page <= 0 {
+			return options, errors.New("invalid page parameter")
+		}
+		options.Page = page
+	}
+	if raw := query.Get("size"); raw != "" {
+		size, err := strconv.Atoi(raw)
+		if err != nil || size <= 0 {
+			return options, errors.New("invalid size parameter")
+		}
+		options.Size = size
+	}
+	return options, nil
+}
+
+// apply 排序狀態清單並取出目前頁數的部分，超過最後一頁時返回空清單
+// 使用穩定排序，排序欄位相同的網址維持 snapshotStatuses 的網址順序
+func (o listOptions) apply(statuses []WebsiteStatus) []WebsiteStatus {
+	less := statusSorters[o.Sort]
+	sort.SliceStable(statuses, func(i, j int) bool { return less(statuses[i], statuses[j]) })
+	if o.Size == 0 {
+		return statuses
+	}
+
+	start := (o.Page - 1) * o.Size
+	if start >= len(statuses) {
+		return []WebsiteStatus{}
+	}
+	end := start + o.Size
+	if end > len(statuses) {
+		end = len(statuses)
+	}
+	return statuses[start:end]
+}
+
+// totalPages 返回 total 筆資料分頁後的總頁數，不分頁時為 1
+func (o listOptions) totalPages(total int) int {
+	if o.Size == 0 || total == 0 {
+		return 1
+	}
+	return (total + o.Size - 1) / o.Size
+}
+
+// 處理 /api/status 請求，以 JSON 返回網站狀態
+// 帶有 ?url= 參數時只返回該網址的狀態，未監控的網址返回 404；
+// 否則返回依 ?state= 與 ?tag= 篩選、依 ?sort= 排序、依 ?page=、?size= 分頁的清單，X-Total-Count 標頭為分頁前的總數
+func apiStatusHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("url") != "" && query.Get("state") != "" {
+		http.Error(w, "url and state parameters cannot be combined", http.StatusBadRequest)
+		return
+	}
+
+	if url := query.Get("url"); url != "" {
+		statusMu.RLock()
+		status, ok := currentStatus[url]
+		statusMu.RUnlock()
+		if !ok {
+			http.Error(w, "URL is not monitored", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, status)
+		return
+	}
+
+	options, err := parseListOptions(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tags, err := parseTagFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	statuses := tags.apply(snapshotStatuses())
+	if raw := query.Get("state"); raw != "" {
+		state, ok := stateFilters[raw]
+		if !ok {
+			http.Error(w, "invalid state parameter, expected one of "+strings.Join(slices.Sorted(maps.Keys(stateFilters)), ", "), http.StatusBadRequest)
+			return
+		}
+		statuses = filterByState(statuses, state)
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(len(statuses)))
+	writeJSON(w, options.apply(statuses))
+}
+
+// clearHistoryHandler 清除 ?url= 網址的歷史紀錄，?all=true 時清除所有網址，
+// 目前狀態保留，Uptime 與回應時間統計從下一次檢查重新計算；清除後立即保存
+func clearHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	url := query.Get("url")
+	all := query.Get("all") == "true"
+	if url == "" && !all {
+		http.Error(w, "missing url parameter (or all=true)", http.StatusBadRequest)
+		return
+	}
+
+	statusMu.Lock()
+	defer statusMu.Unlock()
+
+	var targets []string
+	if all {
+		for url := range currentStatus {
+			targets = append(targets, url)
+		}
+	} else {
+		if _, ok := currentStatus[url]; !ok {
+			http.Error(w, "URL is not monitored", http.StatusNotFound)
+			return
+		}
+		targets = []string{url}
+	}
+
+	for _, url := range targets {
+		if clearer, ok := store.(HistoryClearer); ok {
+			if err := clearer.ClearHistory(url); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		status := currentStatus[url]
+		status.HistoryStatuses = nil
+		status.Uptime = uptimePercentage(nil, uptimeWindow)
+		status.UptimeWindows = nil
+		status.AvgResponseTime, status.MinResponseTime, status.MaxResponseTime = responseTimeStats(nil, statsWindow)
+		status.Histogram = responseTimeHistogram(nil, statsWindow, histogramBuckets)
+		status.Timeline = nil
+		status.Flapping = false
+		currentStatus[url] = status
+		log.Printf("Cleared history for %s", url)
+	}
+
+	if err := store.Save(currentStatus); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	historyDirty = false
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 處理 /metrics 請求，以 Prometheus 文字格式輸出每個網址的指標
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	statusMu.RLock()
+	defer statusMu.RUnlock()
+
+	urls := make([]string, 0, len(metrics))
+	for url := range metrics {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	// 要求 OpenMetrics 格式時，計數器附上最近最慢一次檢查的 exemplar；
+	// OpenMetrics 只允許計數器與直方圖帶有 exemplar，因此不放在回應時間的 gauge 上
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+
+	writeMetric := func(name, help, kind string, value func(m *urlMetrics) string) {
+		family := name
+		if openMetrics && kind == "counter" {
+			family = strings.TrimSuffix(name, "_total")
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", family, help, family, kind)
+		for _, url := range urls {
+			fmt.Fprintf(w, "%s{url=\"%s\"} %s", name, escapeLabelValue(url), value(metrics[url]))
+			if openMetrics && kind == "counter" {
+				if slowest, ok := slowestCheck(currentStatus[url].HistoryStatuses, statsWindow); ok {
+					fmt.Fprintf(w, " # %s", formatExemplar(url, slowest))
+				}
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	writeMetric("website_status_code", "Last HTTP status code, 0 for connection errors.", "gauge", func(m *urlMetrics) string {
+		return strconv.Itoa(m.LastStatus)
+	})
+	writeMetric("website_up", "Whether the last check was successful (1) or not (0).", "gauge", func(m *urlMetrics) string {
+		if m.Up {
+			return "1"
+		}
+		return "0"
+	})
+	writeMetric("website_response_time_seconds", "Response time of the last check in seconds.", "gauge", func(m *urlMetrics) string {
+		return strconv.FormatFloat(m.ResponseSeconds, 'g', -1, 64)
+	})
+	writeMetric("website_checks_total", "Number of checks performed since the monitor started.", "counter", func(m *urlMetrics) string {
+		return strconv.FormatUint(m.Checks, 10)
+	})
+	if openMetrics {
+		fmt.Fprint(w, "# EOF\n")
+	}
+}
+
+// slowestCheck 返回最近 window 筆紀錄中回應時間最長的一筆，與回應時間統計一樣不計連線錯誤
+func slowestCheck(history []HistoryStatus, window int) (HistoryStatus, bool) {
+	if window > 0 && len(history) > window {
+		history = history[len(history)-window:]
+	}
+	var slowest HistoryStatus
+	found := false
+	for _, h := range history {
+		if h.Status != 0 && (!found || h.ResponseTime > slowest.ResponseTime) {
+			slowest = h
+			found = true
+		}
+	}
+	return slowest, found
 }
 
-// statusText 根據狀態碼返回狀態碼的解釋
-func statusText(code int) string {
-	switch code {
-	case 200:
-		return "OK"
-	case 301:
-		return "Moved Permanently"
-	case 302:
-		return "Found"
-	case 400:
-		return "Bad Request"
-	case 401:
-		return "Unauthorized"
-	case 403:
-		return "Forbidden"
-	case 404:
-		return "Not Found"
-	case 500:
-		return "Internal Server Error"
-	case 502:
-		return "Bad Gateway"
-	case 503:
-		return "Service Unavailable"
-	default:
-		return "Unknown Status"
+// maxExemplarLabelLength OpenMetrics 規定 exemplar 標籤名稱與值合計不得超過 128 個字元
+const maxExemplarLabelLength = 128
+
+// formatExemplar 以 OpenMetrics 格式輸出 exemplar：標籤為網址，值為回應秒數，時間戳記為檢查時間；
+// 網址過長時省略標籤，只保留值與時間戳記
+func formatExemplar(url string, h HistoryStatus) string {
+	labels := "{}"
+	if utf8.RuneCountInString("url"+url) <= maxExemplarLabelLength {
+		labels = fmt.Sprintf("{url=\"%s\"}", escapeLabelValue(url))
 	}
+	timestamp := float64(h.CheckedTime.UnixMilli()) / 1000
+	return fmt.Sprintf("%s %s %s", labels,
+		strconv.FormatFloat(h.ResponseTime.Seconds(), 'g', -1, 64),
+		strconv.FormatFloat(timestamp, 'f', 3, 64))
 }
 
-// WebsiteStatus 網站狀態結構
-type WebsiteStatus struct {
-	URL             string
-	Status          int
-	StatusMessage   string
-	LastChecked     time.Time
-	ResponseTime    time.Duration
-	HistoryStatuses []HistoryStatus // 歷史狀態紀錄
+// labelValueEscaper 跳脫 Prometheus 標籤值中的反斜線、雙引號與換行
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue 依 Prometheus 文字格式跳脫標籤值
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
 }
 
-// HistoryStatus 用於記錄歷史狀態的結構
-type HistoryStatus struct {
-	Status        int
-	StatusMessage string
-	CheckedTime   time.Time
-	ResponseTime  time.Duration
+// 處理 /healthz 請求，回報監控程式本身是否正常運作
+// 超過 healthStaleFactor 倍的最長檢查間隔都沒有完成任何檢查，或有重要 (critical) 網址異常時返回 503；
+// 非重要網址的異常只列在 down 中，不影響結果
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	// urls 在重新載入設定時會被替換，與 lastCheckTime 一起在鎖內讀取
+	statusMu.RLock()
+	lastCheck := lastCheckTime
+	var longest time.Duration
+	for _, target := range urls {
+		if d := time.Duration(target.Interval); d > longest {
+			longest = d
+		}
+	}
+	var criticalDown, down []string
+	for url, status := range currentStatus {
+		// 尚未檢查或維護中的網址不算異常
+		if status.Healthy() || status.State == statePending || status.Maintenance {
+			continue
+		}
+		if status.Critical {
+			criticalDown = append(criticalDown, url)
+		} else {
+			down = append(down, url)
+		}
+	}
+	statusMu.RUnlock()
+	sort.Strings(criticalDown)
+	sort.Strings(down)
+
+	// 尚未完成任何檢查時，以啟動時間作為基準
+	since := lastCheck
+	if since.IsZero() {
+		since = processStart
+	}
+	healthy := time.Since(since) <= healthStaleFactor*longest
+
+	body := struct {
+		Status        string    `json:"status"`
+		Uptime        string    `json:"uptime"`
+		UptimeSeconds float64   `json:"uptime_seconds"`
+		LastCheck     time.Time `json:"last_check"`
+		CriticalDown  []string  `json:"critical_down,omitempty"` // 異常中的重要網址
+		Down          []string  `json:"down,omitempty"`          // 異常中的非重要網址
+	}{
+		Status:        "ok",
+		Uptime:        time.Since(processStart).Round(time.Second).String(),
+		UptimeSeconds: time.Since(processStart).Seconds(),
+		LastCheck:     lastCheck,
+		CriticalDown:  criticalDown,
+		Down:          down,
+	}
+	if len(criticalDown) > 0 {
+		body.Status = "critical_down"
+		healthy = false
+	}
+	if uiAuth != nil {
+		// /healthz 不需要驗證，設定 auth 時不列出網址
+		body.CriticalDown, body.Down = nil, nil
+	}
+	if !healthy {
+		if body.Status == "ok" {
+			body.Status = "stale"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(body)
+		return
+	}
+	writeJSON(w, body)
 }
 
-// 變數，以存放目前網站狀態
-var currentStatus = make(map[string]WebsiteStatus)
+// 處理 /api/history 請求，以 JSON 返回單一網址的歷史紀錄
+// ?since= (RFC3339) 只返回該時間之後的紀錄，?limit= 只返回最新的幾筆；DELETE 時清除歷史紀錄
+func apiHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		clearHistoryHandler(w, r)
+		return
+	}
 
-// 監聽網站狀態
-func listenWebsiteStatus() {
-	for {
-		for _, url := range urls {
-			start := time.Now()
+	query := r.URL.Query()
+	url := query.Get("url")
+	if url == "" {
+		http.Error(w, "missing url parameter", http.StatusBadRequest)
+		return
+	}
 
-			resp, err := http.Get(url)
-			if err != nil {
-				updateStatus(url, 0, "Connection Error", start, 0)
-				log.Printf("Error checking %s: %v", url, err)
-			} else {
-				duration := time.Since(start)
-				status := resp.StatusCode
-				statusDescription := statusText(status)
+	var since time.Time
+	if raw := query.Get("since"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			http.Error(w, "invalid since parameter, expected RFC3339 time", http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
 
-				updateStatus(url, status, statusDescription, start, duration)
-				resp.Body.Close()
+	limit := 0
+	if raw := query.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			http.Error(w, "invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
 
-				log.Printf("Checked %s - Status: %s, Response time: %v", url, statusDescription, duration)
-			}
+	statusMu.RLock()
+	status, ok := currentStatus[url]
+	var history []HistoryStatus
+	for _, h := range status.HistoryStatuses {
+		if h.CheckedTime.Before(since) {
+			continue
+		}
+		history = append(history, h)
+	}
+	statusMu.RUnlock()
+
+	if !ok {
+		http.Error(w, "URL is not monitored", http.StatusNotFound)
+		return
+	}
 
-			time.Sleep(interval)
+	// 儲存方式支援範圍查詢時直接查詢，可取得超過記憶體上限的較舊紀錄
+	if querier, ok := store.(HistoryQuerier); ok {
+		stored, err := querier.History(url, since, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
+		writeJSON(w, stored)
+		return
+	}
+
+	if limit > 0 && len(history) > limit {
+		history = history[len(history)-limit:]
+	}
+	if history == nil {
+		history = []HistoryStatus{}
 	}
+	writeJSON(w, history)
 }
 
-// 更新網站狀態
-func updateStatus(url string, status int, statusMessage string, checkedTime time.Time, responseTime time.Duration) {
-	// 檢查是否已經存在於狀態記錄中，如果不存在，則初始化
-	if _, ok := currentStatus[url]; !ok {
-		currentStatus[url] = WebsiteStatus{
-			URL:           url,
-			Status:        status,
-			StatusMessage: statusMessage,
-			LastChecked:   checkedTime,
-			ResponseTime:  responseTime,
-			HistoryStatuses: []HistoryStatus{
-				{Status: status, StatusMessage: statusMessage, CheckedTime: checkedTime, ResponseTime: responseTime},
-			},
-		}
-	} else {
-		// 更新目前狀態，並將新狀態添加到歷史記錄中
-		current := currentStatus[url]
-		current.Status = status
-		current.StatusMessage = statusMessage
-		current.LastChecked = checkedTime
-		current.ResponseTime = responseTime
-		current.HistoryStatuses = append(current.HistoryStatuses, HistoryStatus{
-			Status:        status,
-			StatusMessage: statusMessage,
-			CheckedTime:   checkedTime,
-			ResponseTime:  responseTime,
-		})
-		currentStatus[url] = current
-		saveHistoryToFile() // 保存歷史資料到檔案
+// maxCompareGap 比較時找到的紀錄與指定時間相差超過此值時在 Note 中註明
+const maxCompareGap = 5 * time.Minute
+
+// ComparePoint 比較的一端：指定的時間與最接近的歷史紀錄
+type ComparePoint struct {
+	Requested time.Time
+	Entry     HistoryStatus
+	Healthy   bool
+	OffsetMs  int64  // 紀錄時間減去指定時間的毫秒數，負數代表紀錄在指定時間之前
+	Note      string `json:",omitempty"` // 指定時間附近沒有紀錄時的說明
+}
+
+// Comparison /api/compare 的結果，差異皆為 To 減去 From
+type Comparison struct {
+	URL                string
+	From               ComparePoint
+	To                 ComparePoint
+	StatusChanged      bool
+	HealthChanged      bool
+	ResponseTimeDiffMs int64
+	ResponseTimeChange float64 `json:",omitempty"` // 回應時間變化的百分比，From 的回應時間為 0 時省略
+	TTFBDiffMs         int64
+	Note               string `json:",omitempty"`
+}
+
+// nearestHistory 返回時間最接近 t 的歷史紀錄，history 依時間排序，沒有紀錄時 ok 為 false
+func nearestHistory(history []HistoryStatus, t time.Time) (entry HistoryStatus, ok bool) {
+	if len(history) == 0 {
+		return HistoryStatus{}, false
+	}
+	i := sort.Search(len(history), func(i int) bool { return !history[i].CheckedTime.Before(t) })
+	switch {
+	case i == len(history):
+		return history[i-1], true
+	case i == 0:
+		return history[0], true
+	case t.Sub(history[i-1].CheckedTime) <= history[i].CheckedTime.Sub(t):
+		return history[i-1], true
+	}
+	return history[i], true
+}
+
+// comparePoint 找出最接近 t 的紀錄，相差超過 maxCompareGap 時註明實際使用的紀錄時間
+func comparePoint(history []HistoryStatus, t time.Time) ComparePoint {
+	entry, _ := nearestHistory(history, t)
+	offset := entry.CheckedTime.Sub(t)
+	point := ComparePoint{Requested: t, Entry: entry, Healthy: entry.Healthy(), OffsetMs: offset.Milliseconds()}
+	if offset > maxCompareGap || offset < -maxCompareGap {
+		point.Note = fmt.Sprintf("no check within %v of %s, using the closest one at %s",
+			maxCompareGap, t.UTC().Format(time.RFC3339), entry.CheckedTime.UTC().Format(historyTimeFormat))
 	}
+	return point
 }
 
-// 保存歷史資料到檔案
-func saveHistoryToFile() {
-	file, err := os.Create(historyFileName)
+// 處理 /api/compare 請求，比較單一網址在 ?from= 與 ?to= (RFC3339，預設為現在) 最接近的兩筆歷史紀錄
+func apiCompareHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	url := query.Get("url")
+	if url == "" {
+		http.Error(w, "missing url parameter", http.StatusBadRequest)
+		return
+	}
+	from, err := time.Parse(time.RFC3339, query.Get("from"))
 	if err != nil {
-		log.Printf("Error creating history file: %v", err)
+		http.Error(w, "invalid or missing from parameter, expected RFC3339 time", http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
+	to := time.Now()
+	if raw := query.Get("to"); raw != "" {
+		to, err = time.Parse(time.RFC3339, raw)
+		if err != nil {
+			http.Error(w, "invalid to parameter, expected RFC3339 time", http.StatusBadRequest)
+			return
+		}
+	}
 
-	encoder := json.NewEncoder(file)
-	err = encoder.Encode(currentStatus)
-	if err != nil {
-		log.Printf("Error encoding history to file: %v", err)
+	statusMu.RLock()
+	status, ok := currentStatus[url]
+	var comparison Comparison
+	if ok && len(status.HistoryStatuses) > 0 {
+		comparison = Comparison{
+			URL:  url,
+			From: comparePoint(status.HistoryStatuses, from),
+			To:   comparePoint(status.HistoryStatuses, to),
+		}
 	}
-}
+	statusMu.RUnlock()
 
-// 從檔案讀取歷史資料
-func loadHistoryFromFile() {
-	file, err := os.Open(historyFileName)
-	if err != nil {
-		log.Printf("Error opening history file: %v", err)
+	if !ok {
+		http.Error(w, "URL is not monitored", http.StatusNotFound)
+		return
+	}
+	if comparison.URL == "" {
+		http.Error(w, "no history recorded for this URL yet", http.StatusNotFound)
 		return
 	}
-	defer file.Close()
 
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&currentStatus)
-	if err != nil {
-		log.Printf("Error decoding history from file: %v", err)
+	before, after := comparison.From.Entry, comparison.To.Entry
+	comparison.StatusChanged = before.Status != after.Status
+	comparison.HealthChanged = comparison.From.Healthy != comparison.To.Healthy
+	comparison.ResponseTimeDiffMs = (after.ResponseTime - before.ResponseTime).Milliseconds()
+	comparison.TTFBDiffMs = (after.TTFB - before.TTFB).Milliseconds()
+	if before.ResponseTime > 0 {
+		comparison.ResponseTimeChange = float64(after.ResponseTime-before.ResponseTime) / float64(before.ResponseTime) * 100
 	}
+	if before.CheckedTime.Equal(after.CheckedTime) {
+		comparison.Note = "both times resolved to the same check"
+	}
+	writeJSON(w, comparison)
 }
 
-// 處理主頁請求
-func indexHandler(w http.ResponseWriter, r *http.Request) {
-	funcMap := template.FuncMap{
-		"statusClass": func(status int) string {
-			switch {
-			case status == 200:
-				return "status-ok"
-			case status >= 400 && status < 500:
-				return "status-warning"
-			case status >= 500:
-				return "status-error"
-			default:
-				return ""
+// eventBroker 將狀態更新推送給所有 /events 的訂閱者
+type eventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan WebsiteStatus]struct{}
+	closed      bool
+}
+
+// events 全域的狀態更新推送
+var events = &eventBroker{subscribers: make(map[chan WebsiteStatus]struct{})}
+
+// subscribe 新增一個訂閱者，返回的 channel 在 close 後會被關閉
+func (b *eventBroker) subscribe() chan WebsiteStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan WebsiteStatus, eventBufferSize)
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	b.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe 移除訂閱者
+func (b *eventBroker) unsubscribe(ch chan WebsiteStatus) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// publish 將狀態送給所有訂閱者，只附上最新一筆歷史紀錄以縮小事件大小
+// 訂閱者來不及接收時直接略過，避免拖慢 updateStatus
+func (b *eventBroker) publish(status WebsiteStatus) {
+	if n := len(status.HistoryStatuses); n > 0 {
+		status.HistoryStatuses = status.HistoryStatuses[n-1:]
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- status:
+		default:
+		}
+	}
+}
+
+// close 關閉所有訂閱者，讓 /events 的連線在伺服器關閉時結束
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// 處理 /events 請求，以 Server-Sent Events 推送每次的狀態更新
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			// 用戶端中斷連線
+			return
+		case status, ok := <-ch:
+			if !ok {
+				return
 			}
-		},
-		"toJson": toJson, // 註冊自定義 JSON 序列化函數
+			data, err := json.Marshal(status)
+			if err != nil {
+				log.Printf("Error encoding event: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		}
 	}
+}
 
-	tmpl := template.Must(template.New("index.html").Funcs(funcMap).ParseFiles("index.html"))
+// 處理 /api/incidents 請求，以 JSON 返回單一網址的異常事件
+func apiIncidentsHandler(w http.ResponseWriter, r *http.Request) {
+	url := r.URL.Query().Get("url")
+	if url == "" {
+		http.Error(w, "missing url parameter", http.StatusBadRequest)
+		return
+	}
 
-	// 讀取當前網站狀態
-	var websiteStatuses []WebsiteStatus
-	for _, status := range currentStatus {
-		websiteStatuses = append(websiteStatuses, status)
+	statusMu.RLock()
+	status, ok := currentStatus[url]
+	statusMu.RUnlock()
+	if !ok {
+		http.Error(w, "URL is not monitored", http.StatusNotFound)
+		return
 	}
+	writeJSON(w, findIncidents(status.HistoryStatuses, time.Now()))
+}
 
-	data := struct {
-		WebsiteStatuses []WebsiteStatus
-	}{
-		WebsiteStatuses: websiteStatuses,
+// 處理 /logs 請求，以純文字返回日誌檔案最後 ?n= 行 (預設 100，最多 maxLogLines)
+func logsHandler(w http.ResponseWriter, r *http.Request) {
+	n := defaultLogLines
+	if raw := r.URL.Query().Get("n"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "invalid n parameter", http.StatusBadRequest)
+			return
+		}
+		n = parsed
+	}
+	if n > maxLogLines {
+		n = maxLogLines
 	}
 
-	err := tmpl.Execute(w, data)
+	lines, err := tailFile(logFilePath, n)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// tailFile 從檔案結尾往前讀取，返回最後 n 行
+// 最多只讀取結尾的 maxLogTailBytes 位元組，避免讀入過大的日誌檔案
+func tailFile(path string, n int) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	offset := info.Size()
+	for offset > 0 && bytes.Count(data, []byte("\n")) <= n && int64(len(data)) < maxLogTailBytes {
+		chunk := int64(logTailChunk)
+		if chunk > offset {
+			chunk = offset
+		}
+		offset -= chunk
+
+		buf := make([]byte, chunk)
+		if _, err := file.ReadAt(buf, offset); err != nil {
+			return nil, err
+		}
+		data = append(buf, data...)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if offset > 0 && len(lines) > 0 {
+		// 第一行可能只讀到一半，捨棄
+		lines = lines[1:]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
+
+// AuthConfig 保護網頁與 API 的 HTTP Basic 驗證設定
+// 密碼可用明文 Password 或十六進位的 PasswordSHA256 設定，兩者擇一
+type AuthConfig struct {
+	Username       string `json:"username"`
+	Password       string `json:"password,omitempty"`
+	PasswordSHA256 string `json:"password_sha256,omitempty"`
+}
+
+// uiAuth 網頁與 API 的驗證設定，nil 代表不需要驗證
+var uiAuth *AuthConfig
+
+// requireAuth 在設定 uiAuth 時要求 HTTP Basic 驗證，否則直接交給 next 處理
+func requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if uiAuth != nil {
+			username, password, ok := r.BasicAuth()
+			if !ok || !uiAuth.matches(username, password) {
+				w.Header().Set("WWW-Authenticate", `Basic realm="Website Monitor", charset="UTF-8"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// matches 以固定時間比較帳號密碼，避免從回應時間推測內容
+func (a *AuthConfig) matches(username, password string) bool {
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(a.Username)) == 1
+
+	var passOK bool
+	if a.PasswordSHA256 != "" {
+		sum := sha256.Sum256([]byte(password))
+		passOK = subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(a.PasswordSHA256))) == 1
+	} else {
+		passOK = subtle.ConstantTimeCompare([]byte(password), []byte(a.Password)) == 1
+	}
+	return userOK && passOK
+}
+
+// writeJSON 設定 Content-Type 並將 v 編碼為 JSON 寫入回應
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
 	}
 }
 
@@ -212,32 +5596,288 @@ func toJson(v interface{}) template.JS {
 	return template.JS(js)
 }
 
+// ensureWritableDir 確認檔案所在的目錄存在 (不存在時建立) 且可寫入
+func ensureWritableDir(path string) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("無法建立目錄 %s: %w", dir, err)
+	}
+	probe, err := os.CreateTemp(dir, ".write-test-*")
+	if err != nil {
+		return fmt.Errorf("目錄 %s 無法寫入: %w", dir, err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// envOrDefault 返回環境變數的值，未設定時返回預設值
+func envOrDefault(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// envBoolOrDefault 與 envOrDefault 相同，但將值解析為布林值，格式錯誤時直接結束
+func envBoolOrDefault(key string, fallback bool) bool {
+	value := envOrDefault(key, "")
+	if value == "" {
+		return fallback
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("環境變數 %s 不是有效的布林值: %v", key, err)
+	}
+	return enabled
+}
+
+// envDurationOrDefault 與 envOrDefault 相同，但將值解析為時間長度，格式錯誤時直接結束
+func envDurationOrDefault(key string, fallback time.Duration) time.Duration {
+	value := envOrDefault(key, "")
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("環境變數 %s 不是有效的時間長度: %v", key, err)
+	}
+	return duration
+}
+
 func main() {
+	configFileName := flag.String("config", envOrDefault("WEBSITE_MONITOR_CONFIG", "urls.json"), "監控網址設定檔路徑，也可用環境變數 WEBSITE_MONITOR_CONFIG 設定")
+	timeout := flag.Duration("timeout", envDurationOrDefault("WEBSITE_MONITOR_TIMEOUT", defaultTimeout), "單次請求的逾時時間，也可用環境變數 WEBSITE_MONITOR_TIMEOUT 設定")
+	flag.BoolVar(&debugLogging, "debug", false, "輸出除錯層級的日誌")
+	checkOnly := flag.Bool("check", false, "檢查每個網址一次、印出結果後結束，有網址異常或設定有問題時結束碼為 1")
+	terminal := flag.Bool("terminal", false, "在終端機中顯示每個檢查間隔更新一次的狀態表格，同時照常啟動網頁伺服器")
+	storage := flag.String("storage", envOrDefault("WEBSITE_MONITOR_STORAGE", "json"), "歷史資料儲存方式：json、sqlite (需以 -tags sqlite 編譯) 或 memory (不寫入檔案)，也可用環境變數 WEBSITE_MONITOR_STORAGE 設定")
+	dbFileName := flag.String("db", envOrDefault("WEBSITE_MONITOR_DB", "status_history.db"), "使用 SQLite 儲存時的資料庫檔案路徑，也可用環境變數 WEBSITE_MONITOR_DB 設定")
+	logFormat := flag.String("log-format", envOrDefault("WEBSITE_MONITOR_LOG_FORMAT", "text"), "日誌格式：text 或 json，也可用環境變數 WEBSITE_MONITOR_LOG_FORMAT 設定")
+	addr := flag.String("addr", envOrDefault("WEBSITE_MONITOR_ADDR", defaultAddr), "伺服器監聽位址，也可用環境變數 WEBSITE_MONITOR_ADDR 設定")
+	flag.StringVar(&logFilePath, "log-file", envOrDefault("WEBSITE_MONITOR_LOG_FILE", defaultLogFile), "日誌檔案路徑，也可用環境變數 WEBSITE_MONITOR_LOG_FILE 設定")
+	historyFile := flag.String("history-file", envOrDefault("WEBSITE_MONITOR_HISTORY_FILE", defaultHistoryFile), "使用 JSON 儲存時的歷史狀態檔案路徑，也可用環境變數 WEBSITE_MONITOR_HISTORY_FILE 設定")
+	webDir := flag.String("web-dir", envOrDefault("WEBSITE_MONITOR_WEB_DIR", "."), "覆寫內嵌頁面的目錄，其中的 index.html 與 static/ 優先於內嵌的檔案，也可用環境變數 WEBSITE_MONITOR_WEB_DIR 設定")
+	showVersion := flag.Bool("version", false, "印出版本資訊後結束")
+	compressHistory := flag.Bool("compress-history", envBoolOrDefault("WEBSITE_MONITOR_COMPRESS_HISTORY", false), "使用 JSON 儲存時以 gzip 壓縮歷史狀態檔案，檔名為 -history-file 加上 .gz，也可用環境變數 WEBSITE_MONITOR_COMPRESS_HISTORY 設定")
+	flag.Parse()
+
+	if *showVersion {
+		info := buildInfo()
+		fmt.Printf("website-detection %s (commit %s, built %s, %s)\n", info.Version, info.Commit, info.BuildTime, info.GoVersion)
+		return
+	}
+
+	// 0 會停用 HTTP 逾時，TCP 與 ping 檢查的 context 也會立即到期
+	if *timeout <= 0 {
+		log.Fatalf("-timeout (WEBSITE_MONITOR_TIMEOUT) = %v: 必須大於 0", *timeout)
+	}
+	httpClient.Timeout = *timeout
+
+	// 啟動前確認資料檔案所在的目錄可以寫入，避免執行到一半才失敗
+	dataFiles := []string{logFilePath, *historyFile}
+	switch *storage {
+	case "sqlite":
+		dataFiles = []string{logFilePath, *dbFileName}
+	case "memory":
+		dataFiles = []string{logFilePath}
+	}
+	for _, path := range dataFiles {
+		if err := ensureWritableDir(path); err != nil {
+			log.Fatalf("資料檔案 %s 無法使用: %v", path, err)
+		}
+	}
+
 	// 開啟或創建日誌檔案
-	file, err := os.OpenFile(logFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	file, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
 	if err != nil {
 		log.Fatalf("無法開啟日誌檔案: %v", err)
 	}
 	defer file.Close()
 
-	// 設置日誌輸出
-	log.SetOutput(file)
+	// 設置日誌輸出，JSON 模式下 log.Printf 也會經由 slog 輸出為 JSON
+	switch *logFormat {
+	case "text":
+		log.SetOutput(file)
+	case "json":
+		level := slog.LevelInfo
+		if debugLogging {
+			level = slog.LevelDebug
+		}
+		slog.SetDefault(slog.New(slog.NewJSONHandler(file, &slog.HandlerOptions{Level: level})))
+		jsonLogging = true
+	default:
+		log.Fatalf("未知的日誌格式 %q，可用的格式為 text 或 json", *logFormat)
+	}
+
+	// 從設定檔讀取監控網址
+	config, problems, err := loadConfig(*configFileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "設定檔有誤: %v\n", err)
+		log.Fatalf("Error loading config file: %v", err)
+	}
+	if len(problems) > 0 {
+		// 日誌寫在檔案中，另外在終端機列出問題，避免設定錯誤沒被注意到
+		fmt.Fprintf(os.Stderr, "設定檔有 %d 個問題，已略過該項目或改用預設值:\n", len(problems))
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "  %s\n", problem)
+		}
+	}
+	applyConfig(config)
+	maxRetries = config.Retries
+	jitterFraction = max(config.Jitter, 0)
+	jitterEachCheck = config.JitterEachCheck
+	retryBackoff = time.Duration(config.RetryBackoff)
+	checkSlots = make(chan struct{}, config.MaxConcurrent)
+	applyGlobalProxy(config.Proxy)
+	statusRules = config.StatusClasses
+	redirectsUp = config.RedirectsUp
+	uiAuth = config.Auth
+
+	// 驗證模式：只檢查一次並結束，不讀取歷史資料也不啟動伺服器
+	if *checkOnly {
+		code := runCheckMode(urls)
+		if len(problems) > 0 {
+			code = 1
+		}
+		file.Close()
+		os.Exit(code)
+	}
+
+	// 啟動時解析一次主頁模板，覆寫目錄中有 index.html 時優先使用，否則使用內嵌的模板；
+	// 模板有錯誤時直接結束，而不是在第一個請求時 panic
+	webFS := newWebFS(*webDir)
+	if _, err := os.Stat(filepath.Join(*webDir, "index.html")); err == nil {
+		log.Printf("Using index.html from %s instead of the embedded template", *webDir)
+	}
+	indexTemplate, err = loadTemplate(webFS, "index.html")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "無法載入主頁模板: %v\n", err)
+		log.Fatalf("無法載入主頁模板: %v", err)
+	}
+
+	// 選擇歷史資料的儲存方式並讀取歷史資料
+	switch *storage {
+	case "json":
+		if *compressHistory {
+			store = NewCompressedJSONFileStore(*historyFile)
+		} else {
+			store = NewJSONFileStore(*historyFile)
+		}
+	case "sqlite":
+		store, err = NewSQLiteStore(*dbFileName)
+		if err != nil {
+			log.Fatalf("無法開啟歷史資料庫: %v", err)
+		}
+	case "memory":
+		store = MemoryStore{}
+		log.Printf("Running in memory-only mode, history will be lost on restart")
+	default:
+		log.Fatalf("未知的儲存方式 %q，可用的方式為 json、sqlite 或 memory", *storage)
+	}
+	loadHistory()
+	seedPendingStatuses(urls)
 
-	// 從檔案讀取歷史資料
-	loadHistoryFromFile()
+	// 收到 SIGINT 或 SIGTERM 時取消 ctx，開始正常關閉流程
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// 啟動監聽網站狀態的協程
-	go listenWebsiteStatus()
+	var monitors sync.WaitGroup
+	running := newMonitorSet(ctx, &monitors)
+	running.apply(urls)
+
+	// 收到 SIGHUP 時重新載入設定檔，無效的設定不會套用
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		active := config
+		for {
+			// 設定 remote_urls 時定期重新取得遠端網址清單，設定檔只在收到 SIGHUP 時重新讀取
+			var refresh <-chan time.Time
+			if active.RemoteURLs != nil {
+				refresh = time.After(time.Duration(active.RemoteURLs.Refresh))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-reload:
+			case <-refresh:
+				active = refreshRemoteURLs(active, running)
+				continue
+			}
+			next, err := reloadConfig(*configFileName, active, running)
+			if err != nil {
+				log.Printf("Config reload rejected, keeping the current config: %v", err)
+				continue
+			}
+			active = next
+		}
+	}()
+	go flushHistoryPeriodically(ctx, time.Duration(config.SaveInterval))
 
-	// 設置靜態資源目錄，這裡假設有一個 index.html 作為模板
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-	http.HandleFunc("/", indexHandler)
+	// 靜態檔案同樣先從覆寫目錄的 static/ 讀取，否則使用內嵌的檔案
+	// 除了 /healthz 之外，設定 auth 後所有頁面與 API 都需要驗證
+	staticFS, err := fs.Sub(webFS, "static")
+	if err != nil {
+		log.Fatalf("無法載入靜態檔案: %v", err)
+	}
+	http.Handle("/static/", requireAuth(http.StripPrefix("/static/", http.FileServer(http.FS(staticFS)))))
+	http.Handle("/", requireAuth(http.HandlerFunc(indexHandler)))
+	http.Handle("/api/status", requireAuth(http.HandlerFunc(apiStatusHandler)))
+	http.Handle("/api/summary", requireAuth(http.HandlerFunc(apiSummaryHandler)))
+	http.Handle("/api/metrics-summary", requireAuth(http.HandlerFunc(apiMetricsSummaryHandler)))
+	http.Handle("/api/history", requireAuth(http.HandlerFunc(apiHistoryHandler)))
+	http.Handle("/api/compare", requireAuth(http.HandlerFunc(apiCompareHandler)))
+	http.Handle("/api/incidents", requireAuth(http.HandlerFunc(apiIncidentsHandler)))
+	http.Handle("/metrics", requireAuth(http.HandlerFunc(metricsHandler)))
+	http.HandleFunc("/healthz", healthzHandler)
+	http.Handle("/events", requireAuth(http.HandlerFunc(eventsHandler)))
+	http.Handle("/logs", requireAuth(http.HandlerFunc(logsHandler)))
+	http.Handle("/version", requireAuth(http.HandlerFunc(versionHandler)))
 
-	// 監聽端口
-	port := "8080"
-	fmt.Printf("Starting server on port %s...\n", port)
-	err = http.ListenAndServe(":"+port, nil)
+	// 監聽位址，先建立 listener 才能得知 ":0" 實際分配到的端口
+	listener, err := net.Listen("tcp", *addr)
 	if err != nil {
 		log.Fatalf("無法啟動伺服器: %v", err)
 	}
+	server := &http.Server{}
+	server.RegisterOnShutdown(events.close)
+	go func() {
+		fmt.Printf("Starting server on %s...\n", listener.Addr())
+		log.Printf("Serving on %s (version %s, commit %s, built %s)", listener.Addr(), version, commit, buildTime)
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("無法啟動伺服器: %v", err)
+		}
+	}()
+	if *terminal {
+		go runTerminalView(ctx, listener.Addr().String(), time.Duration(config.Interval))
+	}
+
+	<-ctx.Done()
+	log.Printf("Shutdown signal received, stopping monitors...")
+
+	// 停止伺服器接受新請求，並給進行中的請求一段時間完成
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
+
+	// 等待所有檢查協程結束後，再寫入最後一次的歷史資料；
+	// 進行中的請求綁定 ctx，已經被中斷，不需要等到逾時
+	monitors.Wait()
+
+	statusMu.Lock()
+	err = store.Save(currentStatus)
+	statusMu.Unlock()
+	if err != nil {
+		log.Printf("Error saving history on shutdown: %v", err)
+		return
+	}
+	if err := store.Close(); err != nil {
+		log.Printf("Error closing history store: %v", err)
+		return
+	}
+	log.Printf("History saved cleanly, exiting")
 }
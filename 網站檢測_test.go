@@ -0,0 +1,978 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// resetStatus 讓測試從空白的網站狀態開始，結束後還原
+func resetStatus(t testing.TB) {
+	t.Helper()
+	statusMu.Lock()
+	saved, savedCooldowns := currentStatus, alertCooldowns
+	currentStatus = make(map[string]WebsiteStatus)
+	alertCooldowns = make(map[string]*alertCooldown)
+	statusMu.Unlock()
+	t.Cleanup(func() {
+		statusMu.Lock()
+		currentStatus, alertCooldowns = saved, savedCooldowns
+		statusMu.Unlock()
+	})
+}
+
+// 遠端網址清單必須經由設定檔的全域 proxy 取得，而不依賴尚未寫入的 HTTP_PROXY 環境變數
+func TestFetchRemoteURLsUsesGlobalProxy(t *testing.T) {
+	requests := make(chan string, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.URL.String()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `["https://a.example", {"url": "https://b.example"}]`)
+	}))
+	defer proxy.Close()
+
+	// .invalid 無法解析，只有經由代理伺服器才取得得到
+	const listURL = "http://inventory.invalid/targets.json"
+	targets, err := fetchRemoteURLs(RemoteURLsConfig{URL: listURL}, proxy.URL)
+	if err != nil {
+		t.Fatalf("fetchRemoteURLs: %v", err)
+	}
+	if got := <-requests; got != listURL {
+		t.Errorf("proxy received %q, want %q", got, listURL)
+	}
+	if len(targets) != 2 || targets[0].URL != "https://a.example" || targets[1].URL != "https://b.example" {
+		t.Errorf("targets = %+v, want a.example and b.example", targets)
+	}
+}
+
+// 各探測點連線到不同的 IP，設定 probes 時不應視為 IP 變更
+func TestProbesSkipIPChangeDetection(t *testing.T) {
+	resetStatus(t)
+	probes := []ProbeResult{{Name: "east", Status: 200, Healthy: true}, {Name: "west", Status: 200, Healthy: true}}
+	for _, tc := range []struct {
+		url    string
+		probes []ProbeResult
+		want   bool
+	}{
+		{"https://single.example", nil, true},
+		{"https://probes.example", probes, false},
+	} {
+		start := time.Now()
+		for i, ip := range []string{"192.0.2.1", "192.0.2.2"} {
+			updateStatus(tc.url, CheckResult{Status: 200, CheckedTime: start.Add(time.Duration(i) * time.Second), RemoteIP: ip, Probes: tc.probes})
+		}
+		if got := currentStatus[tc.url].IPChanged; got != tc.want {
+			t.Errorf("%s: IPChanged = %v, want %v", tc.url, got, tc.want)
+		}
+	}
+}
+
+// chanNotifier 將收到的通知送到 channel，供測試檢查送出了哪些通知
+type chanNotifier chan Alert
+
+func (c chanNotifier) Notify(alert Alert) error {
+	c <- alert
+	return nil
+}
+
+// useNotifier 讓測試期間的通知只送給 c
+func useNotifier(t *testing.T, c chanNotifier) {
+	t.Helper()
+	saved := notifiers
+	notifiers = []Notifier{c}
+	t.Cleanup(func() { notifiers = saved })
+}
+
+// receivedAlerts 等待背景送出的通知，返回期間收到的所有通知，依狀態轉換的時間排列
+func receivedAlerts(c chanNotifier) []Alert {
+	var alerts []Alert
+	for {
+		select {
+		case alert := <-c:
+			alerts = append(alerts, alert)
+		case <-time.After(200 * time.Millisecond):
+			sort.Slice(alerts, func(i, j int) bool { return alerts[i].Time.Before(alerts[j].Time) })
+			return alerts
+		}
+	}
+}
+
+// 冷卻期間延後的通知在網站頻繁切換時不應補發
+func TestDeferredAlertNotSentWhileFlapping(t *testing.T) {
+	resetStatus(t)
+	sent := make(chanNotifier, 10)
+	useNotifier(t, sent)
+	savedWindow, savedThreshold := flapWindow, flapThreshold
+	flapWindow, flapThreshold = 10, 4
+	t.Cleanup(func() { flapWindow, flapThreshold = savedWindow, savedThreshold })
+
+	const url = "https://flapping.example"
+	start := time.Now()
+	for _, check := range []struct {
+		at     time.Duration
+		status int
+	}{
+		{0, 200},
+		{1 * time.Second, 500},  // 送出異常通知
+		{2 * time.Second, 200},  // 送出恢復通知
+		{3 * time.Second, 500},  // 仍在異常通知的冷卻期間，延後
+		{4 * time.Second, 200},  // 開始頻繁切換
+		{5 * time.Second, 500},  // 頻繁切換，不通知
+		{30 * time.Second, 500}, // 冷卻已結束，但仍在頻繁切換
+	} {
+		updateStatus(url, CheckResult{Status: check.status, CheckedTime: start.Add(check.at), AlertCooldown: 10 * time.Second})
+	}
+	if !currentStatus[url].Flapping {
+		t.Fatal("URL should be flapping")
+	}
+	alerts := receivedAlerts(sent)
+	if len(alerts) != 2 || alerts[0].Up || !alerts[1].Up {
+		t.Errorf("alerts = %+v, want only the first down and up alerts", alerts)
+	}
+}
+
+// 每個狀態分類都計入自己的欄位，3xx 不應算在 Other
+func TestSummarizeCountsEachState(t *testing.T) {
+	var statuses []WebsiteStatus
+	for _, state := range []string{stateOK, stateOK, stateDegraded, stateRedirect, stateRedirect, stateWarning, stateError, stateMaintenance, statePending, ""} {
+		statuses = append(statuses, WebsiteStatus{State: state})
+	}
+	want := Summary{Total: 10, Up: 2, Degraded: 1, Redirect: 2, Warning: 1, Down: 1, Maintenance: 1, Pending: 1, Other: 1}
+	if got := summarize(statuses); got != want {
+		t.Errorf("summarize = %+v, want %+v", got, want)
+	}
+}
+
+func TestStatusText(t *testing.T) {
+	for _, tc := range []struct {
+		code int
+		want string
+	}{
+		{0, "Connection Error"},
+		{200, "OK"},
+		{204, "No Content"},
+		{206, "Partial Content"},
+		{301, "Moved Permanently"},
+		{307, "Temporary Redirect"},
+		{308, "Permanent Redirect"},
+		{404, "Not Found"},
+		{418, "I'm a teapot"},
+		{429, "Too Many Requests"},
+		{503, "Service Unavailable"},
+		{504, "Gateway Timeout"},
+		{599, "Unknown Status"},
+	} {
+		if got := statusText(tc.code); got != tc.want {
+			t.Errorf("statusText(%d) = %q, want %q", tc.code, got, tc.want)
+		}
+	}
+}
+
+// 以 go test -race 執行：頁面與 API 讀取狀態的同時持續更新狀態，不應出現資料競爭
+func TestHandlersDuringUpdates(t *testing.T) {
+	resetStatus(t)
+	tmpl, err := loadTemplate(newWebFS(t.TempDir()), "index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := indexTemplate
+	indexTemplate = tmpl
+	t.Cleanup(func() { indexTemplate = saved })
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		start := time.Now()
+		for i := 0; i < 200; i++ {
+			url := fmt.Sprintf("https://site%d.example", i%5)
+			updateStatus(url, CheckResult{Status: []int{200, 500}[i%2], CheckedTime: start.Add(time.Duration(i) * time.Second)})
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		for _, handler := range []http.HandlerFunc{indexHandler, apiStatusHandler, apiSummaryHandler} {
+			rec := httptest.NewRecorder()
+			handler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+			}
+		}
+	}
+	<-done
+}
+
+// 檢查間隔的優先順序：網址自身的 interval > 全域 interval > 內建預設值
+func TestURLIntervalPrecedence(t *testing.T) {
+	targets := []URLConfig{
+		{URL: "https://api.example", Interval: Duration(5 * time.Second)},
+		{URL: "https://www.example"},
+	}
+	for _, tc := range []struct {
+		name   string
+		global Duration
+		want   []time.Duration
+	}{
+		{"global interval", Duration(5 * time.Minute), []time.Duration{5 * time.Second, 5 * time.Minute}},
+		{"default interval", 0, []time.Duration{5 * time.Second, defaultInterval}},
+	} {
+		config, problems := normalizeConfig(Config{Interval: tc.global, URLs: slices.Clone(targets)})
+		if len(problems) > 0 {
+			t.Fatalf("%s: problems %v", tc.name, problems)
+		}
+		for i, target := range config.URLs {
+			if got := time.Duration(target.Interval); got != tc.want[i] {
+				t.Errorf("%s: %s interval = %v, want %v", tc.name, target.URL, got, tc.want[i])
+			}
+		}
+	}
+}
+
+// 超過 max_history 時捨棄最舊的紀錄，保留最新的紀錄
+func TestHistoryCap(t *testing.T) {
+	resetStatus(t)
+	saved := maxHistory
+	maxHistory = 5
+	t.Cleanup(func() { maxHistory = saved })
+
+	const url = "https://capped.example"
+	start := time.Now()
+	for i := 0; i < 12; i++ {
+		updateStatus(url, CheckResult{Status: 200, CheckedTime: start.Add(time.Duration(i) * time.Second)})
+	}
+	history := currentStatus[url].HistoryStatuses
+	if len(history) != maxHistory {
+		t.Fatalf("len(history) = %d, want %d", len(history), maxHistory)
+	}
+	for i, entry := range history {
+		if want := start.Add(time.Duration(7+i) * time.Second); !entry.CheckedTime.Equal(want) {
+			t.Errorf("history[%d].CheckedTime = %v, want %v", i, entry.CheckedTime, want)
+		}
+	}
+}
+
+// 編碼失敗時不應動到上一次成功寫入的歷史檔案，也不應留下暫存檔
+func TestJSONFileStoreSaveKeepsFileOnEncodeError(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "status_history.json")
+	store := NewJSONFileStore(path)
+	if err := store.Save(map[string]WebsiteStatus{"https://good.example": {URL: "https://good.example", Status: 200}}); err != nil {
+		t.Fatal(err)
+	}
+	good, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// JSON 無法表示 NaN，Encode 會失敗
+	if err := store.Save(map[string]WebsiteStatus{"https://bad.example": {URL: "https://bad.example", Uptime: math.NaN()}}); err == nil {
+		t.Fatal("Save succeeded, want an encode error")
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, good) {
+		t.Errorf("history file changed after a failed save:\n%s", after)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory has %d entries, want only the history file", len(entries))
+	}
+}
+
+// checkTarget 以與設定檔相同的驗證與預設值檢查一次網址
+func checkTarget(t *testing.T, target URLConfig) CheckResult {
+	t.Helper()
+	config, problems := normalizeConfig(Config{URLs: []URLConfig{target}})
+	if len(problems) > 0 {
+		t.Fatalf("invalid target: %v", problems)
+	}
+	result, _ := checkWithRetries(context.Background(), config.URLs[0])
+	return result
+}
+
+// 以方括號表示的 IPv6 網址可以檢查，並記錄實際連線的 IP；限定 IPv4 時無法連線
+func TestIPv6LiteralURL(t *testing.T) {
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	url := server.URL + "/"
+	if !strings.HasPrefix(url, "http://[::1]:") {
+		t.Fatalf("server URL = %s, want an IPv6 literal", url)
+	}
+	result := checkTarget(t, URLConfig{URL: url})
+	if result.Status != http.StatusOK || result.RemoteIP != "::1" {
+		t.Errorf("Status = %d, RemoteIP = %q, want 200 from ::1 (%s)", result.Status, result.RemoteIP, result.StatusMessage)
+	}
+	if result := checkTarget(t, URLConfig{URL: url, IPVersion: "4"}); result.Status != 0 {
+		t.Errorf("ip_version 4: Status = %d, want a connection error", result.Status)
+	}
+}
+
+// 設定 expected_status 的網址只有返回預期的狀態碼才算正常
+func TestExpectedStatus(t *testing.T) {
+	resetStatus(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		w.WriteHeader(code)
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		code    int
+		healthy bool
+	}{
+		{403, true},
+		{200, false},
+		{401, false},
+		{500, false},
+	} {
+		url := fmt.Sprintf("%s/%d", server.URL, tc.code)
+		result := checkTarget(t, URLConfig{URL: url, ExpectedStatus: []int{403}})
+		if result.Healthy() != tc.healthy {
+			t.Errorf("%d: Healthy() = %v, want %v", tc.code, result.Healthy(), tc.healthy)
+		}
+		updateStatus(url, result)
+		if state := currentStatus[url].State; (state == stateOK) != tc.healthy {
+			t.Errorf("%d: State = %q, want ok only when healthy", tc.code, state)
+		}
+	}
+}
+
+// 狀態清單依網址排序，重複取得時順序不變，不受 map 迭代順序影響
+func TestStatusOrderIsStable(t *testing.T) {
+	resetStatus(t)
+	now := time.Now()
+	for _, host := range []string{"delta", "alpha", "echo", "charlie", "bravo", "golf", "foxtrot"} {
+		updateStatus("https://"+host+".example", CheckResult{Status: 200, CheckedTime: now})
+	}
+
+	var first []byte
+	for i := 0; i < 20; i++ {
+		statuses := snapshotStatuses()
+		if !slices.IsSortedFunc(statuses, func(a, b WebsiteStatus) int { return strings.Compare(a.URL, b.URL) }) {
+			t.Fatalf("snapshotStatuses not sorted by URL: %v", statuses)
+		}
+		rec := httptest.NewRecorder()
+		apiStatusHandler(rec, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+		if first == nil {
+			first = rec.Body.Bytes()
+		} else if !bytes.Equal(rec.Body.Bytes(), first) {
+			t.Fatalf("/api/status changed between calls:\n%s\n%s", first, rec.Body)
+		}
+	}
+}
+
+// stubProxy 記錄經由它送出的請求主機，並對每個請求返回 200
+type stubProxy struct {
+	*httptest.Server
+	mu    sync.Mutex
+	hosts []string
+}
+
+func newStubProxy(t *testing.T) *stubProxy {
+	t.Helper()
+	proxy := &stubProxy{}
+	proxy.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxy.mu.Lock()
+		proxy.hosts = append(proxy.hosts, r.URL.Host)
+		proxy.mu.Unlock()
+	}))
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+func (p *stubProxy) received() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.hosts)
+}
+
+// 網址自身的 proxy 優先，"direct" 代表不經過代理
+func TestURLProxy(t *testing.T) {
+	proxy := newStubProxy(t)
+	if result := checkTarget(t, URLConfig{URL: "http://proxied.invalid/", Proxy: proxy.URL}); result.Status != http.StatusOK {
+		t.Errorf("proxied check: Status = %d (%s), want 200 from the proxy", result.Status, result.StatusMessage)
+	}
+	if result := checkTarget(t, URLConfig{URL: "http://direct.invalid/", Proxy: "direct"}); result.Status != 0 {
+		t.Errorf("direct check: Status = %d, want a connection error", result.Status)
+	}
+	if got := proxy.received(); !slices.Equal(got, []string{"proxied.invalid"}) {
+		t.Errorf("proxy received %v, want only proxied.invalid", got)
+	}
+}
+
+// 全域 proxy 與環境變數一樣遵守 NO_PROXY。http.ProxyFromEnvironment 在每個程序中只讀取一次環境變數，
+// 因此在子程序中執行，並在任何請求前套用全域 proxy
+func TestGlobalProxyHonorsNoProxy(t *testing.T) {
+	const envProxy = "WEBSITE_MONITOR_TEST_PROXY"
+	if proxyURL := os.Getenv(envProxy); proxyURL != "" {
+		applyGlobalProxy(proxyURL)
+		checkTarget(t, URLConfig{URL: "http://external.invalid/"})
+		checkTarget(t, URLConfig{URL: "http://internal.invalid/"})
+		return
+	}
+
+	proxy := newStubProxy(t)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestGlobalProxyHonorsNoProxy$")
+	cmd.Env = append(os.Environ(), envProxy+"="+proxy.URL, "NO_PROXY=internal.invalid", "HTTP_PROXY=", "HTTPS_PROXY=", "http_proxy=", "https_proxy=", "no_proxy=")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("subprocess failed: %v\n%s", err, out)
+	}
+	if got := proxy.received(); !slices.Equal(got, []string{"external.invalid"}) {
+		t.Errorf("proxy received %v, want only external.invalid", got)
+	}
+}
+
+// 版本 1 的歷史檔案 (沒有外層結構、回應時間為奈秒) 可以讀取，保存時升級為目前的版本
+func TestLoadHistorySchemaV1(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "status_history.json")
+	v1 := `{"https://a.example": {"URL": "https://a.example", "Status": 200, "StatusMessage": "OK",
+		"HistoryStatuses": [{"Status": 200, "StatusMessage": "OK", "CheckedTime": "2024-01-02T15:04:05Z", "ResponseTime": 1500000000}]}}`
+	if err := os.WriteFile(path, []byte(v1), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewJSONFileStore(path)
+	statuses, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	status, ok := statuses["https://a.example"]
+	if !ok || status.Status != 200 || len(status.HistoryStatuses) != 1 {
+		t.Fatalf("statuses = %+v, want https://a.example with one history entry", statuses)
+	}
+	entry := status.HistoryStatuses[0]
+	if want := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC); !entry.CheckedTime.Equal(want) || entry.ResponseTime != 1500*time.Millisecond {
+		t.Errorf("entry = %+v, want checked at %v in 1.5s", entry, want)
+	}
+
+	if err := store.Save(statuses); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved historyFile
+	if err := json.Unmarshal(data, &saved); err != nil || saved.SchemaVersion != historySchemaVersion {
+		t.Errorf("saved schema_version = %d (%v), want %d", saved.SchemaVersion, err, historySchemaVersion)
+	}
+}
+
+// gzip 壓縮的回應以解壓縮後的內容進行內容檢查，並分別記錄傳輸與解壓縮後的大小
+func TestGzipResponse(t *testing.T) {
+	body := strings.Repeat("all systems operational\n", 200)
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte(body))
+	gz.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(compressed.Bytes())
+	}))
+	defer server.Close()
+
+	result := checkTarget(t, URLConfig{URL: server.URL, Content: "systems operational"})
+	if !result.Healthy() {
+		t.Fatalf("check failed: %d %s", result.Status, result.StatusMessage)
+	}
+	if result.ContentEncoding != "gzip" || result.WireSize != int64(compressed.Len()) || result.BodySize != int64(len(body)) {
+		t.Errorf("ContentEncoding = %q, WireSize = %d, BodySize = %d, want gzip, %d, %d",
+			result.ContentEncoding, result.WireSize, result.BodySize, compressed.Len(), len(body))
+	}
+}
+
+// 關閉時取消 ctx 應立即中斷進行中的檢查，所有檢查協程結束後不留下任何協程
+func TestShutdownStopsMonitors(t *testing.T) {
+	resetStatus(t)
+	started := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	baseline := runtime.NumGoroutine()
+
+	config, _ := normalizeConfig(Config{Interval: Duration(50 * time.Millisecond), URLs: []URLConfig{{URL: server.URL}, {URL: server.URL + "/other"}}})
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	newMonitorSet(ctx, &wg).apply(config.URLs)
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no check reached the server")
+	}
+
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("monitors still running a second after shutdown")
+	}
+
+	// 被中斷的連線由 Transport 在背景關閉，稍等協程結束
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > baseline {
+		buf := make([]byte, 1<<16)
+		t.Errorf("%d goroutines after shutdown, want at most %d:\n%s", n, baseline, buf[:runtime.Stack(buf, true)])
+	}
+}
+
+// 回應內容的大小必須在 min_body_size 與 max_body_size 之間
+func TestBodySizeRange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		w.Write(bytes.Repeat([]byte("x"), size))
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name    string
+		size    int
+		healthy bool
+	}{
+		{"empty", 0, false},
+		{"under", 99, false},
+		{"min", 100, true},
+		{"within", 150, true},
+		{"max", 200, true},
+		{"over", 201, false},
+		{"far over", 5000, false},
+	} {
+		result := checkTarget(t, URLConfig{URL: fmt.Sprintf("%s/%d", server.URL, tc.size), MinBodySize: 100, MaxBodySize: 200})
+		if result.Healthy() != tc.healthy {
+			t.Errorf("%s (%d bytes): Healthy() = %v, want %v (%s)", tc.name, tc.size, result.Healthy(), tc.healthy, result.StatusMessage)
+		}
+	}
+}
+
+// 回應時間持續改變後，指數移動平均每次以 1-alpha 的比例逼近新的值
+func TestLatencyEMAConverges(t *testing.T) {
+	const alpha = 0.3
+	ema := nextEMA(0, 100*time.Millisecond, alpha)
+	if ema != 100*time.Millisecond {
+		t.Fatalf("first sample: ema = %v, want 100ms", ema)
+	}
+	gap := 400 * time.Millisecond
+	for i := 1; i <= 20; i++ {
+		ema = nextEMA(ema, 500*time.Millisecond, alpha)
+		gap = time.Duration(float64(gap) * (1 - alpha))
+		if diff := 500*time.Millisecond - ema; diff-gap > time.Microsecond || gap-diff > time.Microsecond {
+			t.Fatalf("step %d: ema = %v, want %v", i, ema, 500*time.Millisecond-gap)
+		}
+	}
+	if ema < 495*time.Millisecond {
+		t.Errorf("ema = %v after 20 samples, want within 1%% of 500ms", ema)
+	}
+}
+
+// degraded 以移動平均判斷：單次的延遲尖峰不會造成 degraded，持續變慢才會
+func TestDegradedUsesLatencyEMA(t *testing.T) {
+	resetStatus(t)
+	saved := latencyEMAAlpha
+	latencyEMAAlpha = 0.2
+	t.Cleanup(func() { latencyEMAAlpha = saved })
+
+	const url = "https://slow.example"
+	start := time.Now()
+	check := func(i int, responseTime time.Duration) string {
+		updateStatus(url, CheckResult{Status: 200, CheckedTime: start.Add(time.Duration(i) * time.Second), ResponseTime: responseTime, DegradedThreshold: 500 * time.Millisecond})
+		return currentStatus[url].State
+	}
+	for i := 0; i < 5; i++ {
+		check(i, 100*time.Millisecond)
+	}
+	if state := check(5, time.Second); state != stateOK {
+		t.Errorf("after one spike: State = %q, want ok", state)
+	}
+	state := ""
+	for i := 6; i < 20; i++ {
+		state = check(i, time.Second)
+	}
+	if state != stateDegraded {
+		t.Errorf("after sustained slowness: State = %q, want degraded", state)
+	}
+}
+
+// 無法解析的歷史檔案加上時間戳改名保留，以空的歷史紀錄開始，之後的保存不會覆蓋它
+func TestCorruptHistoryIsQuarantined(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "status_history.json")
+	garbage := []byte("{\"schema_version\": 3, \"statuses\": {\x00 not json")
+	if err := os.WriteFile(path, garbage, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := NewJSONFileStore(path)
+	statuses, err := store.Load()
+	if err != nil || len(statuses) != 0 {
+		t.Fatalf("Load = %v, %v; want empty history and no error", statuses, err)
+	}
+	asides, _ := filepath.Glob(path + ".corrupt-*")
+	if len(asides) != 1 || !regexp.MustCompile(`\.corrupt-\d{8}T\d{6}Z$`).MatchString(asides[0]) {
+		t.Fatalf("quarantined files = %v, want one with a timestamp suffix", asides)
+	}
+	if kept, err := os.ReadFile(asides[0]); err != nil || !bytes.Equal(kept, garbage) {
+		t.Errorf("quarantined file = %q (%v), want the original bytes", kept, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("history file still exists after quarantine: %v", err)
+	}
+
+	if err := store.Save(map[string]WebsiteStatus{"https://a.example": {URL: "https://a.example"}}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if kept, _ := os.ReadFile(asides[0]); !bytes.Equal(kept, garbage) {
+		t.Error("Save overwrote the quarantined file")
+	}
+}
+
+// User-Agent 的優先順序：headers > 網址的 user_agent > 全域的 user_agent > website-detection/<版本>
+func TestUserAgent(t *testing.T) {
+	agents := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents <- r.UserAgent()
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name   string
+		global string
+		target URLConfig
+		want   string
+	}{
+		{"default", "", URLConfig{URL: server.URL}, "website-detection/" + version + " (+https://github.com/ben1980s/Website-detection)"},
+		{"global", "uptime-bot/2", URLConfig{URL: server.URL}, "uptime-bot/2"},
+		{"per URL", "uptime-bot/2", URLConfig{URL: server.URL, UserAgent: "api-probe/1"}, "api-probe/1"},
+		{"header", "uptime-bot/2", URLConfig{URL: server.URL, UserAgent: "api-probe/1", Headers: map[string]string{"User-Agent": "from-header"}}, "from-header"},
+	} {
+		config, problems := normalizeConfig(Config{UserAgent: tc.global, URLs: []URLConfig{tc.target}})
+		if len(problems) > 0 {
+			t.Fatalf("%s: problems %v", tc.name, problems)
+		}
+		if result, _ := checkWithRetries(context.Background(), config.URLs[0]); result.Status != http.StatusOK {
+			t.Fatalf("%s: Status = %d (%s)", tc.name, result.Status, result.StatusMessage)
+		}
+		if got := <-agents; got != tc.want {
+			t.Errorf("%s: User-Agent = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TCP 與 ping 檢查保留不屬於 HTTP 的設定，包括從群組繼承的 degraded_threshold
+func TestNonHTTPChecksKeepDegradedThreshold(t *testing.T) {
+	groups := []GroupConfig{{
+		Name:     "edge",
+		Defaults: URLConfig{DegradedThreshold: Duration(200 * time.Millisecond)},
+		URLs:     []URLConfig{{URL: "tcp://db.example:5432"}, {URL: "ping://gw.example"}},
+	}}
+	config, problems := normalizeConfig(Config{URLs: append(flattenGroups(groups), URLConfig{URL: "tcp://cache.example:6379", DegradedThreshold: Duration(time.Second)})})
+	if len(problems) > 0 {
+		t.Fatalf("problems: %v", problems)
+	}
+	want := map[string]time.Duration{"tcp://db.example:5432": 200 * time.Millisecond, "ping://gw.example": 200 * time.Millisecond, "tcp://cache.example:6379": time.Second}
+	for _, target := range config.URLs {
+		if got := time.Duration(target.DegradedThreshold); got != want[target.URL] {
+			t.Errorf("%s: DegradedThreshold = %v, want %v", target.URL, got, want[target.URL])
+		}
+	}
+}
+
+// 負數的視窗設定記錄為問題並改用預設值
+func TestNegativeWindowsAreReported(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config Config
+		check  func(Config) bool
+	}{
+		{"flap_window", Config{FlapWindow: -3}, func(c Config) bool { return c.FlapWindow == defaultFlapWindow }},
+		{"uptime_window", Config{UptimeWindow: -10}, func(c Config) bool { return c.UptimeWindow == 0 }},
+		{"stats_window", Config{StatsWindow: -10}, func(c Config) bool { return c.StatsWindow == 0 }},
+	} {
+		config, problems := normalizeConfig(tc.config)
+		if len(problems) != 1 || !strings.Contains(problems[0], tc.name+" = -") {
+			t.Errorf("%s: problems = %v, want one problem naming the setting and its value", tc.name, problems)
+		}
+		if !tc.check(config) {
+			t.Errorf("%s: negative value was not replaced with the default: %+v", tc.name, config)
+		}
+	}
+}
+
+// useEscalation 讓測試期間的升級通知在 after 之後送給 c
+func useEscalation(t *testing.T, after time.Duration, c chanNotifier) {
+	t.Helper()
+	savedAfter, savedNotifiers := escalationAfter, escalationNotifiers
+	escalationAfter, escalationNotifiers = after, []Notifier{c}
+	t.Cleanup(func() {
+		statusMu.Lock()
+		for url := range escalations {
+			cancelEscalation(url)
+		}
+		escalationAfter, escalationNotifiers = savedAfter, savedNotifiers
+		statusMu.Unlock()
+	})
+}
+
+// 第一次檢查就異常或頻繁切換時沒有即時通知，持續異常仍應送出一次升級通知
+func TestEscalationWithoutImmediateAlert(t *testing.T) {
+	resetStatus(t)
+	escalated := make(chanNotifier, 10)
+	useEscalation(t, 50*time.Millisecond, escalated)
+	savedWindow, savedThreshold := flapWindow, flapThreshold
+	flapWindow, flapThreshold = 10, 2
+	t.Cleanup(func() { flapWindow, flapThreshold = savedWindow, savedThreshold })
+
+	now := time.Now()
+	// 第一次檢查就異常，沒有可比較的狀態
+	for i := 0; i < 3; i++ {
+		updateStatus("https://down.example", CheckResult{Status: 500, CheckedTime: now.Add(time.Duration(i) * time.Millisecond)})
+	}
+	// 頻繁切換後停在異常
+	for i, status := range []int{200, 500, 200, 500, 500} {
+		updateStatus("https://flapping.example", CheckResult{Status: status, CheckedTime: now.Add(time.Duration(i) * time.Millisecond)})
+	}
+	if !currentStatus["https://flapping.example"].Flapping {
+		t.Fatal("URL should be flapping")
+	}
+
+	// 升級通知送出後仍然異常，同一次異常不會再次升級
+	time.Sleep(100 * time.Millisecond)
+	updateStatus("https://down.example", CheckResult{Status: 500, CheckedTime: time.Now()})
+	time.Sleep(100 * time.Millisecond)
+	got := map[string]int{}
+	for _, alert := range receivedAlerts(escalated) {
+		if !alert.Escalation {
+			t.Errorf("unexpected alert %+v", alert)
+		}
+		got[alert.URL]++
+	}
+	if got["https://down.example"] != 1 || got["https://flapping.example"] != 1 {
+		t.Errorf("escalations per URL = %v, want one each", got)
+	}
+}
+
+// 基準測試的歷史資料規模：20 個網址、每個保留預設的 1000 筆紀錄
+const (
+	benchmarkURLs    = 20
+	benchmarkHistory = defaultMaxHistory
+)
+
+// fillBenchmarkHistory 以 updateStatus 產生與實際執行相同結構的歷史資料，返回使用的網址
+func fillBenchmarkHistory(b *testing.B) []string {
+	b.Helper()
+	resetStatus(b)
+	// 狀態轉換的日誌與基準測試無關
+	output := log.Writer()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(output) })
+	urls := make([]string, benchmarkURLs)
+	start := time.Now().Add(-benchmarkHistory * time.Minute)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("https://site%02d.example/health", i)
+	}
+	for n := 0; n < benchmarkHistory; n++ {
+		for i, url := range urls {
+			status := 200
+			if (n+i)%97 == 0 {
+				status = 503
+			}
+			updateStatus(url, CheckResult{
+				Status: status, StatusMessage: statusText(status), CheckedTime: start.Add(time.Duration(n) * time.Minute),
+				ResponseTime: time.Duration(80+(n*7+i*13)%400) * time.Millisecond, ContentType: "text/html; charset=utf-8", ContentLength: 5120,
+			})
+		}
+	}
+	return urls
+}
+
+// useStore 讓基準測試期間以 s 保存歷史資料
+func useStore(b *testing.B, s Store) {
+	b.Helper()
+	saved := store
+	store = s
+	b.Cleanup(func() { store = saved })
+}
+
+// 比較每次檢查都重寫歷史檔案，與標記變更後由 flushHistoryPeriodically 定期保存的成本；
+// 定期保存以每輪檢查完所有網址保存一次估算，實際的 save_interval 通常涵蓋更多次檢查
+func BenchmarkHistoryPersistence(b *testing.B) {
+	for _, bc := range []struct {
+		name           string
+		checksPerFlush int
+	}{
+		{"save every check", 1},
+		{"debounced", benchmarkURLs},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			urls := fillBenchmarkHistory(b)
+			useStore(b, NewJSONFileStore(filepath.Join(b.TempDir(), "status_history.json")))
+			start := time.Now()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				updateStatus(urls[i%len(urls)], CheckResult{Status: 200, CheckedTime: start.Add(time.Duration(i) * time.Second), ResponseTime: 120 * time.Millisecond})
+				if (i+1)%bc.checksPerFlush == 0 {
+					flushHistory()
+				}
+			}
+		})
+	}
+}
+
+// 保存 20 個網址、每個 1000 筆紀錄的歷史檔案，bytes/save 為寫入的檔案大小，用於比較壓縮的大小與 CPU 成本
+func BenchmarkJSONFileStoreSave(b *testing.B) {
+	for _, bc := range []struct {
+		name     string
+		newStore func(path string) *JSONFileStore
+	}{
+		{"plain", NewJSONFileStore},
+		{"gzip", NewCompressedJSONFileStore},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			fillBenchmarkHistory(b)
+			s := bc.newStore(filepath.Join(b.TempDir(), "status_history.json"))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := s.Save(currentStatus); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			info, err := os.Stat(s.path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(float64(info.Size()), "bytes/save")
+		})
+	}
+}
+
+// 讀取 BenchmarkJSONFileStoreSave 寫入的歷史檔案，比較解壓縮增加的啟動時間
+func BenchmarkJSONFileStoreLoad(b *testing.B) {
+	for _, bc := range []struct {
+		name     string
+		newStore func(path string) *JSONFileStore
+	}{
+		{"plain", NewJSONFileStore},
+		{"gzip", NewCompressedJSONFileStore},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			fillBenchmarkHistory(b)
+			s := bc.newStore(filepath.Join(b.TempDir(), "status_history.json"))
+			if err := s.Save(currentStatus); err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.Load(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// 只重試異常的 5xx，expected_status 或 health_rules 接受的 5xx 不重試
+func TestRetrySkipsExpectedStatus(t *testing.T) {
+	savedRetries, savedBackoff := maxRetries, retryBackoff
+	maxRetries, retryBackoff = 2, time.Millisecond
+	t.Cleanup(func() { maxRetries, retryBackoff = savedRetries, savedBackoff })
+
+	var mu sync.Mutex
+	hits := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		target URLConfig
+		want   int
+	}{
+		{URLConfig{URL: server.URL + "/plain"}, 3},
+		{URLConfig{URL: server.URL + "/expected", ExpectedStatus: []int{503}}, 1},
+		{URLConfig{URL: server.URL + "/rule", HealthRules: []HealthRule{{Status: []int{503}, Headers: map[string]string{"Retry-After": ""}}}}, 1},
+	} {
+		checkTarget(t, tc.target)
+		mu.Lock()
+		got := hits[strings.TrimPrefix(tc.target.URL, server.URL)]
+		mu.Unlock()
+		if got != tc.want {
+			t.Errorf("%s: %d requests, want %d", tc.target.URL, got, tc.want)
+		}
+	}
+}
+
+// 群組 defaults 的 ping_count 由群組內的網址繼承，網址自身的設定優先
+func TestGroupPingCount(t *testing.T) {
+	groups := []GroupConfig{{
+		Name:     "routers",
+		Defaults: URLConfig{PingCount: 5},
+		URLs:     []URLConfig{{URL: "ping://gw1.example"}, {URL: "ping://gw2.example", PingCount: 10}},
+	}}
+	config, problems := normalizeConfig(Config{URLs: append(flattenGroups(groups), URLConfig{URL: "ping://other.example"})})
+	if len(problems) > 0 {
+		t.Fatalf("problems: %v", problems)
+	}
+	want := map[string]int{"ping://gw1.example": 5, "ping://gw2.example": 10, "ping://other.example": defaultPingCount}
+	for _, target := range config.URLs {
+		if target.PingCount != want[target.URL] {
+			t.Errorf("%s: PingCount = %d, want %d", target.URL, target.PingCount, want[target.URL])
+		}
+	}
+}
+
+// 無效的 ?state= 返回 400，訊息列出所有可用的值
+func TestInvalidStateFilterListsAllStates(t *testing.T) {
+	rec := httptest.NewRecorder()
+	apiStatusHandler(rec, httptest.NewRequest(http.MethodGet, "/api/status?state=bogus", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+	for name := range stateFilters {
+		if !strings.Contains(rec.Body.String(), name) {
+			t.Errorf("message %q does not mention %q", rec.Body, name)
+		}
+	}
+}